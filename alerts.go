@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	alertsEvalInterval = 10 * time.Second
+	alertActionTimeout = 30 * time.Second
+)
+
+// Alert states
+const (
+	alertInactive = "inactive"
+	alertPending  = "pending" // the condition is true but not yet for the `for` period
+	alertFiring   = "firing"
+	alertResolved = "resolved" // sent to actions only, the rule becomes inactive
+)
+
+// AlertRule is an alert definition from the config file. The value it
+// checks is a counter, its growth over `window` or its `rate`
+// ("1m", "5m" or "15m" EWMA). If `ratio_to` is set the value is divided
+// by the sum of these counters taken the same way.
+type AlertRule struct {
+	Name      string         `json:"name"`
+	Counter   string         `json:"counter"`
+	RatioTo   []string       `json:"ratio_to,omitempty"`
+	Window    jsonDuration   `json:"window,omitempty"`
+	Rate      string         `json:"rate,omitempty"`
+	Op        string         `json:"op"`
+	Threshold float64        `json:"threshold"`
+	For       jsonDuration   `json:"for,omitempty"`
+	Actions   []*AlertAction `json:"actions"`
+}
+
+// AlertAction is run when an alert starts firing and when it is resolved.
+// Type is one of "webhook" (POST of JSON payload to URL), "exec" (Command
+// gets the payload on STDIN) or "log".
+type AlertAction struct {
+	Type    string   `json:"type"`
+	URL     string   `json:"url,omitempty"`
+	Command []string `json:"command,omitempty"`
+}
+
+// AlertStatus is the current state of an alert rule, listed by /alerts
+type AlertStatus struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Op        string    `json:"op"`
+	Since     time.Time `json:"since"` // when the current state was entered
+}
+
+// AlertPayload is sent to webhook and exec actions
+type AlertPayload struct {
+	Alert     string    `json:"alert"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Op        string    `json:"op"`
+	Threshold float64   `json:"threshold"`
+	Since     time.Time `json:"since"`
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+}
+
+type alertEngine struct {
+	sync.Mutex
+	rules  []*AlertRule
+	status []AlertStatus
+}
+
+var alerts *alertEngine // nil if there are no alert rules
+
+func (r *AlertRule) check() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is not set")
+	}
+	for _, c := range append([]string{r.Counter}, r.RatioTo...) {
+		if !strArrayLookup(PostfixStatusNames[:], c) {
+			return fmt.Errorf("unknown counter %q", c)
+		}
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("incorrect op %q", r.Op)
+	}
+	switch r.Rate {
+	case "", "1m", "5m", "15m":
+	default:
+		return fmt.Errorf("rate can be one of \"1m\", \"5m\" or \"15m\"")
+	}
+	if r.Rate != "" && r.Window != 0 {
+		return fmt.Errorf("rate and window cannot be used together")
+	}
+	for _, a := range r.Actions {
+		if err := a.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AlertAction) check() error {
+	switch {
+	case a.Type == "webhook" && a.URL != "":
+	case a.Type == "exec" && len(a.Command) > 0:
+	case a.Type == "log":
+	default:
+		return fmt.Errorf("incorrect action %q", a.Type)
+	}
+	return nil
+}
+
+// value returns the current value of the rule expression, ok is false
+// if it cannot be calculated (no history, division by zero)
+func (r *AlertRule) value() (float64, bool) {
+	get := func(counter string) (float64, bool) {
+		switch {
+		case r.Rate != "":
+			if rates == nil {
+				return 0, false
+			}
+			v := rates.get()[counter]
+			return map[string]float64{"1m": v.M1, "5m": v.M5, "15m": v.M15}[r.Rate], true
+		case r.Window != 0:
+			if history == nil {
+				return 0, false
+			}
+			return float64(history.windowSum(counter, time.Duration(r.Window))), true
+		default:
+			msgStatusCounters.lock()
+			defer msgStatusCounters.unlock()
+			return float64(msgStatusCounters.value(counter)), true
+		}
+	}
+
+	v, ok := get(r.Counter)
+	if !ok || len(r.RatioTo) == 0 {
+		return v, ok
+	}
+	var div float64
+	for _, c := range r.RatioTo {
+		d, ok := get(c)
+		if !ok {
+			return 0, false
+		}
+		div += d
+	}
+	if div == 0 {
+		return 0, false
+	}
+	return v / div, true
+}
+
+func (r *AlertRule) matches(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	case "==":
+		return v == r.Threshold
+	case "!=":
+		return v != r.Threshold
+	}
+	return false
+}
+
+func newAlertEngine(rules []*AlertRule) *alertEngine {
+	e := &alertEngine{rules: rules, status: make([]AlertStatus, len(rules))}
+	for i, r := range rules {
+		e.status[i] = AlertStatus{Name: r.Name, State: alertInactive, Op: r.Op, Threshold: r.Threshold}
+	}
+	return e
+}
+
+func (e *alertEngine) run() {
+	ticker := time.NewTicker(alertsEvalInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		e.evaluate(now)
+	}
+}
+
+// evaluate checks all the rules and runs actions on state changes
+func (e *alertEngine) evaluate(now time.Time) {
+	for i, r := range e.rules {
+		v, ok := r.value()
+		active := ok && r.matches(v)
+
+		e.Lock()
+		st := &e.status[i]
+		st.Value = v
+		notify := ""
+		switch {
+		case active && st.State == alertInactive:
+			st.State, st.Since = alertPending, now
+			if r.For == 0 {
+				st.State, notify = alertFiring, alertFiring
+			}
+		case active && st.State == alertPending:
+			if now.Sub(st.Since) >= time.Duration(r.For) {
+				st.State, st.Since, notify = alertFiring, now, alertFiring
+			}
+		case !active && st.State == alertFiring:
+			st.State, st.Since, notify = alertInactive, now, alertResolved
+		case !active && st.State == alertPending:
+			st.State, st.Since = alertInactive, now
+		}
+		payload := AlertPayload{Alert: r.Name, State: notify, Value: v, Op: r.Op,
+			Threshold: r.Threshold, Since: st.Since, Time: now}
+		e.Unlock()
+
+		if notify != "" {
+			payload.Host, _ = os.Hostname()
+			for _, a := range r.Actions {
+				go a.run(payload)
+			}
+		}
+	}
+}
+
+func (e *alertEngine) list() []AlertStatus {
+	e.Lock()
+	defer e.Unlock()
+	res := make([]AlertStatus, len(e.status))
+	copy(res, e.status)
+	return res
+}
+
+func (a *AlertAction) run(p AlertPayload) {
+	if a.Type == "log" {
+		fmt.Printf("Alert %s is %s: value %g %s %g\n", p.Alert, p.State, p.Value, p.Op, p.Threshold)
+		return
+	}
+
+	data, _ := json.Marshal(p)
+	ctx, cancel := context.WithTimeout(context.Background(), alertActionTimeout)
+	defer cancel()
+
+	switch a.Type {
+	case "webhook":
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(data))
+		if err != nil {
+			fmt.Printf("Alert %s webhook error: %s\n", p.Alert, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Printf("Alert %s webhook error: %s\n", p.Alert, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			fmt.Printf("Alert %s webhook returned %s\n", p.Alert, resp.Status)
+		}
+	case "exec":
+		cmd := exec.CommandContext(ctx, a.Command[0], a.Command[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Env = append(os.Environ(),
+			"MLOGTAIL_ALERT="+p.Alert,
+			"MLOGTAIL_STATE="+p.State,
+			fmt.Sprintf("MLOGTAIL_VALUE=%g", p.Value))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			fmt.Printf("Alert %s command %q error: %s %s\n", p.Alert,
+				strings.Join(a.Command, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+}
+
+// handleAlerts обрабатывает запрос /alerts
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	res := []AlertStatus{}
+	if alerts != nil {
+		res = alerts.list()
+	}
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFileAlerts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mlogtail.json")
+	os.WriteFile(path, []byte(`{"alerts": [
+		{"name": "bounce-ratio", "counter": "bounced", "ratio_to": ["delivered", "bounced"],
+		 "window": "15m", "op": ">", "threshold": 0.05, "for": "5m",
+		 "actions": [{"type": "log"}, {"type": "exec", "command": ["/bin/true"]}]}
+	]}`), 0644)
+
+	if err := loadConfigFile(new(Config), path); err == nil {
+		t.Error("no error for a window rule without history")
+	}
+	cfg := &Config{historyRetention: 48 * time.Hour}
+	if err := loadConfigFile(cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.alertRules) != 1 {
+		t.Fatalf("Expected 1 alert rule, got %d", len(cfg.alertRules))
+	}
+	r := cfg.alertRules[0]
+	if time.Duration(r.Window) != 15*time.Minute || time.Duration(r.For) != 5*time.Minute {
+		t.Errorf("incorrect durations in %+v", r)
+	}
+
+	bad := []string{
+		`{"alerts": [{"name": "x", "counter": "nonexistent", "op": ">"}]}`,
+		`{"alerts": [{"name": "x", "counter": "bounced", "op": "~"}]}`,
+		`{"alerts": [{"name": "x", "counter": "bounced", "op": ">", "rate": "2m"}]}`,
+		`{"alerts": [{"name": "x", "counter": "bounced", "op": ">", "actions": [{"type": "webhook"}]}]}`,
+		`{"alerts": [{"name": "x", "counter": "bounced", "op": ">", "for": 5}]}`,
+	}
+	for _, b := range bad {
+		os.WriteFile(path, []byte(b), 0644)
+		if err := loadConfigFile(new(Config), path); err == nil {
+			t.Errorf("Expected error for config %s", b)
+		}
+	}
+}
+
+func TestAlertEngine(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+
+	payloads := make(chan AlertPayload, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p AlertPayload
+		json.NewDecoder(r.Body).Decode(&p)
+		payloads <- p
+	}))
+	defer srv.Close()
+
+	rule := &AlertRule{Name: "bounce-ratio", Counter: "bounced", RatioTo: []string{"delivered"},
+		Op: ">", Threshold: 0.05, For: jsonDuration(time.Minute),
+		Actions: []*AlertAction{{Type: "webhook", URL: srv.URL}}}
+	e := newAlertEngine([]*AlertRule{rule})
+	now := time.Date(2025, 4, 1, 10, 0, 0, 0, time.UTC)
+
+	msgStatusCounters.counters["delivered"] = 100
+	msgStatusCounters.counters["bounced"] = 10
+	e.evaluate(now)
+	if st := e.list()[0]; st.State != alertPending || st.Value != 0.1 {
+		t.Errorf("Expected pending state with value 0.1, got %+v", st)
+	}
+
+	e.evaluate(now.Add(time.Minute))
+	if st := e.list()[0]; st.State != alertFiring {
+		t.Errorf("Expected firing state, got %+v", st)
+	}
+	select {
+	case p := <-payloads:
+		if p.Alert != "bounce-ratio" || p.State != alertFiring {
+			t.Errorf("unexpected webhook payload %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+
+	msgStatusCounters.counters["delivered"] = 1000
+	e.evaluate(now.Add(2 * time.Minute))
+	if st := e.list()[0]; st.State != alertInactive {
+		t.Errorf("Expected inactive state, got %+v", st)
+	}
+	select {
+	case p := <-payloads:
+		if p.State != alertResolved {
+			t.Errorf("Expected resolved payload, got %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called on resolve")
+	}
+}
+
+func TestAlertRuleNoDeliveries(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	history = newCounterHistory(time.Hour)
+	defer func() { history = nil }()
+	history.sample(time.Now())
+
+	rule := &AlertRule{Name: "no-deliveries", Counter: "delivered",
+		Window: jsonDuration(30 * time.Minute), Op: "==", Threshold: 0}
+	if v, ok := rule.value(); !ok || !rule.matches(v) {
+		t.Errorf("Expected the rule to match without deliveries, got %v %v", v, ok)
+	}
+	msgStatusCounters.inc("delivered", 1)
+	if v, ok := rule.value(); !ok || rule.matches(v) {
+		t.Errorf("Expected the rule not to match after a delivery, got %v %v", v, ok)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// antispamScoreBounds are upper bounds of the spam score histogram buckets
+var antispamScoreBounds = []float64{0, 2, 5, 8, 10, 15, 20}
+
+var antispamCounters = counterGroup{
+	name: "antispam",
+	names: []string{"antispam-clean", "antispam-spam", "antispam-virus",
+		"antispam-banned", "antispam-bad-header"},
+	labeled: []labeledCounter{
+		{name: "antispam-spam-action", label: "action"},
+		{name: "antispam-score", label: "score", bounds: antispamScoreBounds},
+		{name: "antispam-milter-action", label: "action"},
+	},
+}
+
+var antispamModule = logModule{name: "antispam", group: &antispamCounters, parse: antispamLineParse,
+	postfix: antispamMilterParse}
+
+// rspamd task log "(default: T (reject): [16.50/15.00] [...])"
+var reRspamdResult = regexp.MustCompile(`\(\S+: [TFS] \(([^)]+)\): \[(-?[\d.]+)/`)
+
+// antispamLineParse counts verdicts of amavis
+// "(01234-05) Passed CLEAN {RelayedInbound}, ... Hits: -1.2, size: 1000, ..."
+// "(01234-06) Blocked INFECTED (Eicar-Signature) {DiscardedInbound,Quarantined}, ...",
+// SpamAssassin spamd "spamd: result: Y 7 - BAYES_99,... scantime=0.5,..."
+// and rspamd "... (default: T (reject): [16.50/15.00] [BAYES_SPAM(5.10){...},...]), len: 1000, ..."
+func antispamLineParse(prefix, tag, msg string) {
+	var verdict, action, score string
+	switch tag {
+	case "amavis", "amavisd", "amavisd-new":
+		i := strings.Index(msg, ") Passed ")
+		if i < 0 {
+			if i = strings.Index(msg, ") Blocked "); i < 0 {
+				return
+			}
+		}
+		f := strings.Fields(msg[i+2:])
+		if len(f) < 2 {
+			return
+		}
+		action = strings.ToLower(f[0])
+		switch category := strings.TrimRight(f[1], ","); {
+		case category == "CLEAN":
+			verdict = "clean"
+		case category == "SPAM" || category == "SPAMMY":
+			verdict = "spam"
+		case category == "INFECTED":
+			verdict = "virus"
+		case category == "BANNED":
+			verdict = "banned"
+		case strings.HasPrefix(category, "BAD-HEADER"):
+			verdict = "bad-header"
+		}
+		if j := strings.Index(msg, ", Hits: "); j >= 0 {
+			score, _, _ = strings.Cut(msg[j+8:], ",")
+		}
+	case "spamd":
+		f := strings.Fields(strings.TrimPrefix(msg, "spamd: "))
+		if len(f) < 3 || f[0] != "result:" {
+			return
+		}
+		verdict, score = "clean", f[2]
+		if f[1] == "Y" {
+			verdict, action = "spam", "flagged"
+		}
+	case "rspamd":
+		sMatch := reRspamdResult.FindStringSubmatch(msg)
+		if sMatch == nil {
+			return
+		}
+		verdict, score = "clean", sMatch[2]
+		if sMatch[1] != "no action" {
+			verdict, action = "spam", sMatch[1]
+		}
+	default:
+		return
+	}
+
+	msgStatusCounters.lock()
+	defer msgStatusCounters.unlock()
+	if verdict != "" {
+		msgStatusCounters.inc("antispam-"+verdict, 1)
+	}
+	if verdict == "spam" {
+		msgStatusCounters.incLabel("antispam-spam-action", action, 1)
+	}
+	if v, err := strconv.ParseFloat(score, 64); err == nil {
+		msgStatusCounters.incLabel("antispam-score", histogramLabel(antispamScoreBounds, v), 1)
+	}
+}
+
+// antispamMilterParse counts actions of milters like rspamd or amavis in
+// Postfix lines "cleanup[123]: QID: milter-reject: END-OF-MESSAGE from x[192.0.2.1]: 5.7.1 Spam message rejected; ..."
+// and "smtpd[123]: NOQUEUE: milter-discard: RCPT from x[192.0.2.1]: milter triggers DISCARD action; ...".
+// A reject with a 4xx code is counted as "tempfail". s is the rest after "postfix/".
+func antispamMilterParse(s string) {
+	daemon, msg, ok := postfixDaemon(s)
+	if !ok || daemon != "cleanup" && daemon != "smtpd" {
+		return
+	}
+	i := strings.Index(msg, ": milter-")
+	if i < 0 {
+		return
+	}
+	action, rest, ok := strings.Cut(msg[i+9:], ": ")
+	if !ok || strings.ContainsAny(action, " ") {
+		return
+	}
+	if action == "reject" {
+		if _, reply, ok := strings.Cut(rest, "]: "); ok && strings.HasPrefix(reply, "4") {
+			action = "tempfail"
+		}
+	}
+	msgStatusCounters.lock()
+	msgStatusCounters.incLabel("antispam-milter-action", action, 1)
+	msgStatusCounters.unlock()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAntispamLineParse(t *testing.T) {
+	if err := setLogTypes("postfix,antispam"); err != nil {
+		t.Fatal(err)
+	}
+	defer setLogTypes("postfix")
+	PostfixParserInit(&Config{cmd: "file"})
+
+	prefix := "Apr  1 10:00:00 mail "
+	lines := []string{
+		"amavis[1234]: (01234-05) Passed CLEAN {RelayedInbound}, [192.0.2.1]:40000 [192.0.2.1] <a@example.net> -> <b@example.com>, Queue-ID: 0A2D132D5F, Message-ID: <1@example.net>, mail_id: x1, Hits: -1.2, size: 1000, queued_as: 1B2D132D5F, 512 ms",
+		"amavis[1234]: (01234-06) Blocked SPAM {DiscardedInbound,Quarantined}, [192.0.2.2]:40000 [192.0.2.2] <s@example.org> -> <b@example.com>, Queue-ID: 2C2D132D5F, mail_id: x2, Hits: 12.5, size: 2000, 700 ms\n",
+		"amavisd[1234]: (01234-07) Passed SPAMMY {RelayedTaggedInbound}, [192.0.2.3]:40000 <s@example.org> -> <b@example.com>, Hits: 6.1, size: 2000, 700 ms",
+		"amavis[1234]: (01234-08) Blocked INFECTED (Eicar-Signature) {DiscardedInbound,Quarantined}, [192.0.2.4]:40000 <v@example.org> -> <b@example.com>, Hits: -, size: 500",
+		"amavis[1234]: (01234-09) Blocked BANNED (multipart/mixed | application/x-msdownload,.exe,x.exe) {DiscardedInbound}, <v@example.org> -> <b@example.com>, Hits: -",
+		"amavis[1234]: (01234-10) Passed BAD-HEADER-0 {RelayedInbound,Quarantined}, <a@example.net> -> <b@example.com>, Hits: 1.5",
+		"amavis[1234]: (01234-11) extra modules loaded: /usr/share/perl5/Mail/SpamAssassin/Plugin/Bayes.pm",
+		"spamd[200]: spamd: result: Y 7 - BAYES_99,HTML_MESSAGE scantime=0.5,size=3000,user=nobody,uid=65534,required_score=5.0",
+		"spamd[200]: spamd: result: . 0 - BAYES_00 scantime=0.3,size=1000,user=nobody,uid=65534,required_score=5.0",
+		"spamd[200]: spamd: clean message (0.3/5.0) for nobody:65534 in 0.3 seconds, 1000 bytes.",
+		"rspamd[300]: <a1b2c3>; task; rspamd_task_write_log: id: <3@example.net>, qid: <3D2D132D5F>, ip: 192.0.2.5, from: <a@example.net>, (default: F (no action): [-0.50/15.00] [ARC_NA(0.00){},R_SPF_ALLOW(-0.20){+ip4:192.0.2.0/24;}]), len: 1500, time: 120.1ms",
+		"rspamd[300]: <a1b2c4>; task; rspamd_task_write_log: id: <4@example.net>, qid: <4E2D132D5F>, ip: 192.0.2.6, from: <s@example.org>, (default: T (reject): [16.50/15.00] [BAYES_SPAM(5.10){99.0%;}]), len: 2500, time: 90.0ms",
+		"rspamd[300]: <a1b2c5>; task; rspamd_task_write_log: id: <5@example.net>, (default: F (add header): [7.10/15.00] [BAYES_SPAM(5.10){}]), len: 2500",
+		"dovecot: imap-login: Login: user=<b@example.com>, method=PLAIN, rip=192.0.2.7",
+	}
+	for _, l := range lines {
+		PostfixLineParse(prefix + l)
+	}
+
+	want := map[string]uint64{
+		"antispam-clean": 3, "antispam-spam": 5, "antispam-virus": 1, "antispam-banned": 1, "antispam-bad-header": 1,
+	}
+	for name, v := range want {
+		if got := msgStatusCounters.counters[name]; got != v {
+			t.Errorf("Expected %s=%d, got %d", name, v, got)
+		}
+	}
+	labeled := map[string]map[string]uint64{
+		"antispam-spam-action": {"blocked": 1, "passed": 1, "flagged": 1, "reject": 1, "add header": 1},
+		"antispam-score":       {"<=0": 3, "0..2": 1, "5..8": 3, "10..15": 1, "15..20": 1},
+	}
+	for name, want := range labeled {
+		if got := msgStatusCounters.labeled[name]; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %s=%v, got %v", name, want, got)
+		}
+	}
+	if stats := PostfixStats(); !strings.Contains(stats, "\nantispam-score[5..8]    3\n") {
+		t.Errorf("unexpected stats:\n%s", stats)
+	}
+}
+
+func TestAntispamScoreBucket(t *testing.T) {
+	for v, want := range map[float64]string{-3: "<=0", 0: "<=0", 4.99: "2..5", 5: "2..5", 19.9: "15..20", 20: "15..20", 100: ">20"} {
+		if got := histogramLabel(antispamScoreBounds, v); got != want {
+			t.Errorf("histogramLabel(%g) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestAntispamMilterParse(t *testing.T) {
+	if err := setLogTypes("antispam"); err != nil {
+		t.Fatal(err)
+	}
+	defer setLogTypes("postfix")
+	PostfixParserInit(&Config{cmd: "file"})
+
+	prefix := "Apr  1 10:00:00 mail postfix/"
+	for _, l := range []string{
+		"cleanup[100]: 0A2D132D5F: milter-reject: END-OF-MESSAGE from unknown[192.0.2.1]: 5.7.1 Spam message rejected; from=<s@example.org> to=<b@example.com> proto=ESMTP helo=<spam>",
+		"cleanup[100]: 1B2D132D5F: milter-reject: END-OF-MESSAGE from unknown[192.0.2.2]: 4.7.1 Try again later; from=<s@example.org> to=<b@example.com> proto=ESMTP helo=<spam>\n",
+		"cleanup[100]: 2C2D132D5F: milter-discard: END-OF-MESSAGE from unknown[192.0.2.3]: milter triggers DISCARD action; from=<s@example.org> to=<b@example.com> proto=ESMTP helo=<spam>",
+		"smtpd[101]: NOQUEUE: milter-reject: RCPT from unknown[192.0.2.4]: 554 5.7.1 Rejected; from=<s@example.org> to=<b@example.com> proto=ESMTP helo=<spam>",
+		"cleanup[100]: 3D2D132D5F: milter-hold: END-OF-MESSAGE from unknown[192.0.2.5]: milter triggers HOLD action; from=<s@example.org> to=<b@example.com> proto=ESMTP helo=<x>",
+		"cleanup[100]: 4E2D132D5F: message-id=<1@example.net>",
+	} {
+		PostfixLineParse(prefix + l)
+	}
+	want := map[string]uint64{"reject": 2, "tempfail": 1, "discard": 1, "hold": 1}
+	if got := msgStatusCounters.labeled["antispam-milter-action"]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected antispam-milter-action=%v, got %v", want, got)
+	}
+	if got := msgStatusCounters.counters["rejected"]; got != 0 {
+		t.Errorf("Postfix lines are counted with -t antispam: rejected=%d", got)
+	}
+}
+
+func TestAntispamScoreMetrics(t *testing.T) {
+	if err := setLogTypes("postfix,antispam"); err != nil {
+		t.Fatal(err)
+	}
+	defer setLogTypes("postfix")
+	PostfixParserInit(&Config{cmd: "file"})
+	for _, score := range []string{"-1", "0", "3", "3", "25"} {
+		PostfixLineParse("Apr  1 10:00:00 mail spamd[200]: spamd: result: . " + score + " - BAYES_00 scantime=0.3")
+	}
+	m := metricsString()
+	for _, line := range []string{
+		"# TYPE mlogtail_antispam_score histogram\n",
+		"mlogtail_antispam_score_bucket{le=\"0\"} 2\n",
+		"mlogtail_antispam_score_bucket{le=\"2\"} 2\n",
+		"mlogtail_antispam_score_bucket{le=\"5\"} 4\n",
+		"mlogtail_antispam_score_bucket{le=\"20\"} 4\n",
+		"mlogtail_antispam_score_bucket{le=\"+Inf\"} 5\nmlogtail_antispam_score_count 5\n",
+	} {
+		if !strings.Contains(m, line) {
+			t.Errorf("no %q in metrics:\n%s", line, m)
+		}
+	}
+	if strings.Contains(m, "mlogtail_antispam_score_total") {
+		t.Error("the score histogram is exported as a labeled counter")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const bucketTimeLayout = "2006-01-02 15:04"
+
+var bucketFormats = []string{"table", "csv", "json"}
+
+// bucketWriter splits file mode counters into intervals by the log line
+// time and writes a row per interval as soon as it is complete. Lines
+// are expected to be sorted, a line a bit out of order is counted in the
+// current interval.
+type bucketWriter struct {
+	size    time.Duration
+	format  string
+	w       io.Writer
+	csv     *csv.Writer
+	now     time.Time
+	until   time.Time         // the last interval ends here if not zero
+	current time.Time         // start of the interval being counted
+	prev    map[string]uint64 // counters at the start of the current interval
+}
+
+func newBucketWriter(w io.Writer, cfg *Config) *bucketWriter {
+	b := &bucketWriter{size: cfg.bucket, format: cfg.bucketFormat, w: w, now: time.Now(),
+		prev: make(map[string]uint64)}
+	if cfg.filter != nil {
+		if !cfg.filter.since.IsZero() {
+			b.current = b.start(cfg.filter.since)
+		}
+		b.until = cfg.filter.until
+	}
+
+	switch b.format {
+	case "table":
+		fmt.Fprintf(w, "%-18s", "time")
+		for _, name := range PostfixStatusNames {
+			fmt.Fprintf(w, "%16s", name)
+		}
+		fmt.Fprintln(w)
+	case "csv":
+		b.csv = csv.NewWriter(w)
+		b.csv.Write(append([]string{"time"}, PostfixStatusNames[:]...))
+	}
+	return b
+}
+
+// start returns the start of the interval of t, intervals are aligned
+// in the local time zone, so "-bucket 24h" gives calendar days
+func (b *bucketWriter) start(t time.Time) time.Time {
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(b.size).Add(-shift)
+}
+
+// line is called before a line is parsed, it writes the previous
+// intervals if the line starts a new one
+func (b *bucketWriter) line(s string) {
+	if b == nil {
+		return
+	}
+	ts, ok := logLineTime(s, b.now)
+	if !ok {
+		return
+	}
+	start := b.start(ts)
+	if b.current.IsZero() {
+		b.current = start
+	}
+	b.writeUntil(start)
+}
+
+// writeUntil writes the current interval and zero ones before end
+func (b *bucketWriter) writeUntil(end time.Time) {
+	for b.current.Before(end) {
+		b.write()
+		b.current = b.current.Add(b.size)
+	}
+}
+
+// finish writes the rest of intervals at the end of the file
+func (b *bucketWriter) finish() {
+	if b == nil || b.current.IsZero() {
+		return
+	}
+	end := b.current.Add(b.size)
+	if !b.until.IsZero() && b.until.After(end) {
+		end = b.until
+	}
+	b.writeUntil(end)
+	if b.csv != nil {
+		b.csv.Flush()
+	}
+}
+
+func (b *bucketWriter) write() {
+	values := make([]uint64, len(PostfixStatusNames))
+	for i, name := range PostfixStatusNames {
+		values[i] = msgStatusCounters.counters[name] - b.prev[name]
+		b.prev[name] = msgStatusCounters.counters[name]
+	}
+
+	switch b.format {
+	case "table":
+		var row strings.Builder
+		fmt.Fprintf(&row, "%-18s", b.current.Format(bucketTimeLayout))
+		for _, v := range values {
+			fmt.Fprintf(&row, "%16d", v)
+		}
+		fmt.Fprintln(b.w, row.String())
+	case "csv":
+		row := []string{b.current.Format(time.RFC3339)}
+		for _, v := range values {
+			row = append(row, strconv.FormatUint(v, 10))
+		}
+		b.csv.Write(row)
+	case "json": // JSON lines
+		row := map[string]any{"time": b.current.Format(time.RFC3339)}
+		for i, name := range PostfixStatusNames {
+			row[name] = values[i]
+		}
+		data, _ := json.Marshal(row)
+		fmt.Fprintf(b.w, "%s\n", data)
+	}
+}
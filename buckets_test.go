@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucketWriter(t *testing.T) {
+	cfg := &Config{cmd: "file", bucket: time.Hour, bucketFormat: "csv"}
+	PostfixParserInit(cfg)
+	var out bytes.Buffer
+	b := newBucketWriter(&out, cfg)
+	for _, l := range []string{
+		"Apr  1 09:10:00 mail postfix/smtpd[1]: AD59432D61: client=unknown[192.0.2.1]",
+		"Apr  1 09:20:00 mail postfix/smtp[2]: AD59432D61: to=<bob@example.net>, relay=mx.example.net[1.2.3.4]:25, dsn=2.0.0, status=sent (250 Ok)",
+		"Apr  1 12:00:00 mail postfix/smtpd[1]: AD59432D62: client=unknown[192.0.2.1]",
+		"Apr  1 11:59:59 mail postfix/smtpd[1]: AD59432D63: client=unknown[192.0.2.1]",
+	} {
+		b.line(l)
+		PostfixLineParse(l)
+	}
+	b.finish()
+
+	first, _ := logLineTime("Apr  1 09:00:00", time.Now())
+	want := "time," + strings.Join(PostfixStatusNames[:], ",") + "\n"
+	for i, row := range []string{"0,0,1,1,0,0,0,0,0,0", "0,0,0,0,0,0,0,0,0,0", "0,0,0,0,0,0,0,0,0,0", "0,0,2,0,0,0,0,0,0,0"} {
+		want += first.Add(time.Duration(i)*time.Hour).Format(time.RFC3339) + "," + row + "\n"
+	}
+	if out.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	loc := time.FixedZone("MSK", 3*3600)
+	b := &bucketWriter{size: 24 * time.Hour}
+	got := b.start(time.Date(2025, 4, 1, 1, 30, 0, 0, loc))
+	if want := time.Date(2025, 4, 1, 0, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("start = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The fast classifier below finds the same as the regular expressions
+// in postfix.go do, but scans bytes instead. It dispatches on the daemon
+// name and looks for "status=", "size=", "removed" and "reject: " only
+// in lines of the daemons which log them. Any change of the expressions
+// should be repeated here, TestClassifyDifferential compares both.
+
+// classifyPostfixLine finds out the kind of a line. It does not depend
+// on the previous lines, so lines may be classified in parallel.
+func classifyPostfixLine(s string) postfixLine {
+	var l postfixLine
+	if l.prefixLen = postfixPrefixLen(s); l.prefixLen == 0 {
+		return l
+	}
+	msg := s[l.prefixLen:]
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		if i != len(msg)-1 { // several lines in one, never happens in logs
+			return classifyPostfixLineRegex(s)
+		}
+		msg = msg[:i]
+	}
+
+	// lines starting with "daemon[pid]: ", the smtpd names are the ones
+	// receivedLine and rejectLine match
+	if daemon, rest, ok := splitDaemon(msg); ok {
+		switch daemon {
+		case "smtpd", "smtps", "smtps//smtpd", "smtps//smtps", "submission/smtpd", "submission/smtps", "pickup":
+			if queueID, r, ok := cutQueueID(rest); ok && (strings.HasPrefix(r, "client=") || strings.HasPrefix(r, "uid=")) {
+				l.kind, l.queueID = lineReceived, queueID
+				return l
+			}
+		case "qmgr":
+			if queueID, r, ok := cutQueueID(rest); ok {
+				if size, ok := queueActiveSize(r); ok {
+					l.kind, l.queueID, l.size = lineQueueActive, queueID, size
+					return l
+				}
+				if strings.HasPrefix(r, "removed") {
+					l.kind, l.queueID = lineRemoved, queueID
+					return l
+				}
+			}
+		case "postsuper":
+			if queueID, r, ok := cutQueueID(rest); ok && strings.HasPrefix(r, "removed") {
+				l.kind, l.queueID = lineRemoved, queueID
+				return l
+			}
+		}
+	}
+
+	if strings.Contains(msg, "forwarded as ") {
+		l.kind = lineForwarded
+		return l
+	}
+	for _, st := range []struct {
+		status string
+		kind   lineKind
+	}{{" status=sent", lineDelivered}, {" status=bounced", lineBounced}, {" status=deferred", lineDeferred}} {
+		if queueID, ok := statusQueueID(msg, st.status); ok {
+			l.kind, l.queueID = st.kind, queueID
+			return l
+		}
+	}
+
+	if daemon, rest, ok := splitDaemon(msg); ok {
+		switch daemon {
+		case "smtpd", "smtps", "smtps//smtpd", "smtps//smtps", "submission/smtpd", "submission/smtps", "cleanup":
+			if hasReject(rest) {
+				l.kind = lineRejected
+				return l
+			}
+		}
+	}
+	if strings.Contains(msg, ": NOQUEUE: discard: ") {
+		l.kind = lineDiscarded
+	} else if strings.Contains(msg, ": NOQUEUE: hold: ") {
+		l.kind = lineHeld
+	}
+	return l
+}
+
+// postfixPrefixLen returns the length of "Jul 22 19:06:42 host postfix/"
+// like rePostfixLogLine matches, or 0
+func postfixPrefixLen(s string) int {
+	i := classicTimeLen(s)
+	if i == 0 {
+		i = isoTimeLen(s)
+	}
+	if i == 0 || i >= len(s) || s[i] != ' ' {
+		return 0
+	}
+	i++
+	j := i
+	for j < len(s) && !isRegexpSpace(s[j]) {
+		j++
+	}
+	if j == i || !strings.HasPrefix(s[j:], " postfix") {
+		return 0
+	}
+	for j += 8; j < len(s) && s[j] != '/' && s[j] != ' '; j++ {
+	}
+	if j == len(s) || s[j] != '/' {
+		return 0
+	}
+	return j + 1
+}
+
+// classicTimeLen matches "Jul 22 19:06:42"
+func classicTimeLen(s string) int {
+	if len(s) < 15 ||
+		strings.IndexByte("JAMDFONS", s[0]) < 0 || strings.IndexByte("aeucop", s[1]) < 0 ||
+		strings.IndexByte("nrbcglptvy", s[2]) < 0 || s[3] != ' ' ||
+		strings.IndexByte("123 ", s[4]) < 0 || !isDigit(s[5]) || s[6] != ' ' || !isClock(s[7:15]) {
+		return 0
+	}
+	return 15
+}
+
+// isoTimeLen matches "2025-07-22T19:06:42" with optional fractions of
+// a second and a time zone
+func isoTimeLen(s string) int {
+	if len(s) < 19 || !isDigits(s[:4]) || s[4] != '-' || s[5] != '0' && s[5] != '1' || !isDigit(s[6]) ||
+		s[7] != '-' || s[8] < '0' || s[8] > '3' || !isDigit(s[9]) || s[10] != 'T' || !isClock(s[11:19]) {
+		return 0
+	}
+	i := 19
+	if i < len(s) && s[i] == '.' {
+		j := i + 1
+		for j < len(s) && isDigit(s[j]) {
+			j++
+		}
+		if j == i+1 {
+			return 0
+		}
+		i = j
+	}
+	if i < len(s) && s[i] == 'Z' {
+		i++
+	} else if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		j := i + 3
+		if j > len(s) || !isDigits(s[i+1:j]) {
+			return 0
+		}
+		if j < len(s) && s[j] == ':' {
+			j++
+		}
+		if j+2 > len(s) || !isDigits(s[j:j+2]) {
+			return 0
+		}
+		i = j + 2
+	}
+	return i
+}
+
+// isClock matches "19:06:42" as [0-2]\d:[0-5]\d:[0-5]\d
+func isClock(s string) bool {
+	return s[0] >= '0' && s[0] <= '2' && isDigit(s[1]) && s[2] == ':' &&
+		s[3] >= '0' && s[3] <= '5' && isDigit(s[4]) && s[5] == ':' &&
+		s[6] >= '0' && s[6] <= '5' && isDigit(s[7])
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+func isQueueIDChar(b byte) bool { return isDigit(b) || b >= 'A' && b <= 'F' }
+
+// isRegexpSpace is \s of regexp
+func isRegexpSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\f' || b == '\r'
+}
+
+func isWordChar(b byte) bool {
+	return isDigit(b) || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '_'
+}
+
+// splitDaemon splits "smtpd[123]: rest" into "smtpd" and "rest"
+func splitDaemon(msg string) (string, string, bool) {
+	i := strings.IndexByte(msg, '[')
+	if i < 0 {
+		return "", "", false
+	}
+	j := i + 1
+	for j < len(msg) && isDigit(msg[j]) {
+		j++
+	}
+	if j == i+1 || !strings.HasPrefix(msg[j:], "]: ") {
+		return "", "", false
+	}
+	return msg[:i], msg[j+3:], true
+}
+
+// cutQueueID splits "AD59432D65: rest" into the queue ID and "rest"
+func cutQueueID(s string) (string, string, bool) {
+	i := 0
+	for i < len(s) && isQueueIDChar(s[i]) {
+		i++
+	}
+	if i == 0 || !strings.HasPrefix(s[i:], ": ") {
+		return "", "", false
+	}
+	return s[:i], s[i+2:], true
+}
+
+// queueActiveSize finds the message size in the rest of qmgr line
+// "from=<x@example.com>, size=1000, nrcpt=1 (queue active)". As the
+// regexp it takes the last "size=" followed by "queue active".
+func queueActiveSize(s string) (uint64, bool) {
+	for end := len(s); end > 0; {
+		i := strings.LastIndex(s[:end], " size=")
+		if i < 0 {
+			break
+		}
+		end = i
+		j := i + 6
+		for j < len(s) && isDigit(s[j]) {
+			j++
+		}
+		if n := j - i - 6; n < 2 || n > 12 || j == len(s) || s[j] != ',' && s[j] != ' ' {
+			continue
+		}
+		if j+2 <= len(s) && strings.Contains(s[j+2:], "queue active") {
+			size, _ := strconv.ParseUint(s[i+6:j], 10, 64)
+			return size, true
+		}
+	}
+	return 0, false
+}
+
+// statusQueueID finds "[pid]: QUEUEID: ... status=sent" anywhere in
+// a line and returns the queue ID
+func statusQueueID(msg, status string) (string, bool) {
+	last := strings.LastIndex(msg, status)
+	if last < 0 {
+		return "", false
+	}
+	for i := strings.IndexByte(msg, '['); i >= 0 && i < last; {
+		j := i + 1
+		for j < len(msg) && isDigit(msg[j]) {
+			j++
+		}
+		if j > i+1 && strings.HasPrefix(msg[j:], "]: ") {
+			if queueID, _, ok := cutQueueID(msg[j+3:]); ok && last >= j+3+len(queueID)+3 {
+				return queueID, true
+			}
+		}
+		k := strings.IndexByte(msg[i+1:], '[')
+		if k < 0 {
+			break
+		}
+		i += k + 1
+	}
+	return "", false
+}
+
+// hasReject finds "reject: " at a word boundary
+func hasReject(s string) bool {
+	for i := 0; ; {
+		k := strings.Index(s[i:], "reject: ")
+		if k < 0 {
+			return false
+		}
+		if i+k == 0 || !isWordChar(s[i+k-1]) {
+			return true
+		}
+		i += k + 1
+	}
+}
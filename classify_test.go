@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// classifyTestLines are lines close to the edges of the regexps
+var classifyTestLines = []string{
+	"Jul 22 19:06:42 mail postfix/smtpd[15500]: AD59432D65: client=mail1.example.com[123.123.123.123]",
+	"Jul 22 19:06:42 mail postfix/smtps/smtpd[15500]: AD59432D65: client=mail1.example.com[1.2.3.4]",
+	"Jul 22 19:06:42 mail postfix/submission/smtps[14518]: AD59432D65: client=c[1.2.3.4], sasl_method=PLAIN, sasl_username=a@b.c",
+	"Jul 22 19:06:42 mail postfix/submission/smtp[14518]: AD59432D65: client=c[1.2.3.4]",
+	"Jul 22 19:06:42 mail postfix/pickup[13652]: AD59432D65: uid=65534 from=<nobody>",
+	"Jul 22 19:06:42 mail postfix/smtpd[15500]: ad59432d65: client=mail1.example.com[1.2.3.4]",
+	"Jul 22 19:06:42 mail postfix/smtpd[]: AD59432D65: client=mail1.example.com[1.2.3.4]",
+	"Jul 22 19:06:42 mail postfix/smtpd[15500]: AD59432D65:client=mail1.example.com[1.2.3.4]",
+	"Jul 22 19:06:42 mail postfix-out/smtpd[15500]: AD59432D65: client=mail1.example.com[1.2.3.4]\n",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F: from=<x@example.com>, size=1000, nrcpt=1 (queue active)",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F: from=<x@example.com>, size=1, nrcpt=1 (queue active)",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F: from=<x@example.com>, size=1234567890123, nrcpt=1 (queue active)",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F: from=<x@example.com>, size=123456789012, nrcpt=1 (queue active)",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F: from=<x size=99, y>, size=1000, (queue active)",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F: from=<x size=99, y>, size=1000,queue active",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F:  size=10,queue active",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F:  size=10, queue active",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F:  size=10 ",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F: size=10, x queue active",
+	"Jul 22 19:06:42 mail postfix/qmgr[4753]: 2B69A469711: removed",
+	"Jul 22 19:06:42 mail postfix/qmgr[4753]: 2B69A469711: removed\n",
+	"Jul 22 19:06:42 mail postfix/postsuper[4753]: 2B69A469711: removed",
+	"Jul 22 19:06:42 mail postfix/postsuper[4753]: 2B69A469711: removedx",
+	"Jul 22 19:06:42 mail postfix/cleanup[4753]: 2B69A469711: removed",
+	"Jul 22 19:06:42 mail postfix/local[17781]: 9093C182F98: to=<x@example.com>, relay=local, dsn=2.0.0, status=sent (forwarded as 96643182F99)",
+	"Jul 22 19:06:42 mail postfix/smtp[30345]: 923745823B: to=<user@example.com>, relay=mx[1.2.3.4]:25, dsn=2.6.0, status=sent",
+	"Jul 22 19:06:42 mail postfix/smtp[30345]: 923745823B: status=sent",
+	"Jul 22 19:06:42 mail postfix/smtp[30345]: 923745823B: x status=sent",
+	"Jul 22 19:06:42 mail postfix/smtp[30345]: 923745823B:  status=sent",
+	"Jul 22 19:06:42 mail postfix/smtp[x]: [12]: 9237: to=<a@b.c>, status=bounced (user unknown)",
+	"Jul 22 19:06:42 mail postfix/smtp[1]: zz: [2]: AB: x [3]: CD: y status=deferred",
+	"Jul 22 19:06:42 mail postfix/smtp[1]: AB: [2]: CD: status=deferred",
+	"Jul 22 19:06:42 mail postfix/error[1]: AB: to=<a@b.c>, status=deferred (delivery temporarily suspended)",
+	"Jul 22 19:06:42 mail postfix/smtpd[103]: NOQUEUE: reject: RCPT from unknown[198.51.100.1]: 554 5.7.1 Relay access denied",
+	"Jul 22 19:06:42 mail postfix/cleanup[103]: 2B69A469711: reject: header Subject: spam",
+	"Jul 22 19:06:42 mail postfix/cleanup[103]: 2B69A469711: milter-reject: END-OF-MESSAGE",
+	"Jul 22 19:06:42 mail postfix/cleanup[103]: 2B69A469711: xreject: header",
+	"Jul 22 19:06:42 mail postfix/smtpd[103]: reject: x",
+	"Jul 22 19:06:42 mail postfix/smtpd[103]: xreject: x _reject: reject: x",
+	"Jul 22 19:06:42 mail postfix/smtp[103]: NOQUEUE: reject: x",
+	"Jul 22 19:06:42 mail postfix/cleanup[1]: 2B69A469711: discard: header Subject: x; from=<a@b.c>",
+	"Jul 22 19:06:42 mail postfix/smtpd[1]: NOQUEUE: discard: RCPT from x[1.2.3.4]: <a@b.c>: Recipient address triggers DISCARD action",
+	"Jul 22 19:06:42 mail postfix/smtpd[1]: NOQUEUE: hold: RCPT from x[1.2.3.4]: <a@b.c>: Recipient address triggers HOLD action",
+	"Jul 22 19:06:42 mail postfix/smtpd[1]: connect from unknown[192.0.2.1]",
+	"Jul 22 19:06:42 mail postfix/smtpd[1]: AD59432D65: client=a[1.2.3.4]\nJul 22 19:06:42 mail postfix/smtp[2]: AB: x status=sent\n",
+	"Jul 22 19:06:42 mail postfix/smtp[30345]: 923745823B: to=<a@b.c>,\tdsn=2.0.0,\tstatus=sent\r\n",
+	"Jul 22 19:06:42 mail postfix/smtp[30345]: 923745823B: to=<\xff\xfe>, \xc3 status=sent",
+	"Jul 22 19:06:42 mail postfix/qmgr[29052]: 0A2D132D5F: size=10,\xc3\xa9queue active",
+	"Jul  2 19:06:42 mail postfix/smtpd[1]: AD59432D65: client=a[1.2.3.4]",
+	"Jul 2 19:06:42 mail postfix/smtpd[1]: AD59432D65: client=a[1.2.3.4]",
+	"Jul 22 39:06:42 mail postfix/smtpd[1]: AD59432D65: client=a[1.2.3.4]",
+	"Jux 22 19:06:42 mail postfix/smtpd[1]: AD59432D65: client=a[1.2.3.4]",
+	"Jul 22 19:06:42  mail postfix/smtpd[1]: AD59432D65: client=a[1.2.3.4]",
+	"Jul 22 19:06:42 mail\tpostfix/smtpd[1]: AD59432D65: client=a[1.2.3.4]",
+	"Jul 22 19:06:42 mail sendmail/smtpd[1]: AD59432D65: client=a[1.2.3.4]",
+	"Jul 22 19:06:42 mail postfix-a b/smtpd[1]: AD59432D65: client=a[1.2.3.4]",
+	"Jul 22 19:06:42 mail postfix",
+	"Jul 22 19:06:42 mail postfix/",
+	"Jul 22 19:06:42 ",
+	"2025-07-22T19:06:42.123456+03:00 mail postfix/smtpd[1]: AD59432D65: client=a[1.2.3.4]",
+	"2025-07-22T19:06:42+0300 mail postfix/qmgr[1]: AB: from=<>, size=1000, nrcpt=1 (queue active)",
+	"2025-07-22T19:06:42Z mail postfix/qmgr[1]: AB: removed",
+	"2025-07-22T19:06:42 mail postfix/smtp[1]: AB: to=<a@b.c>, status=sent",
+	"2025-07-22T19:06:42. mail postfix/smtp[1]: AB: to=<a@b.c>, status=sent",
+	"2025-07-22T19:06:42+03: mail postfix/smtp[1]: AB: to=<a@b.c>, status=sent",
+	"2025-07-22T19:06:42+03:0 mail postfix/smtp[1]: AB: to=<a@b.c>, status=sent",
+	"2025-07-22T19:06:42+03:00Z mail postfix/smtp[1]: AB: to=<a@b.c>, status=sent",
+	"2025-27-22T19:06:42 mail postfix/smtp[1]: AB: to=<a@b.c>, status=sent",
+	"",
+	"\n",
+}
+
+func TestClassifyDifferential(t *testing.T) {
+	lines := append([]string{}, classifyTestLines...)
+	for _, line := range strings.SplitAfter(parallelTestLog(2000), "\n") {
+		lines = append(lines, line)
+	}
+	for _, line := range lines {
+		if got, want := classifyPostfixLine(line), classifyPostfixLineRegex(line); got != want {
+			t.Errorf("%q: got %+v, want %+v", line, got, want)
+		}
+	}
+}
+
+// TestClassifyCounters checks that both classifiers give the same
+// counters on a large log
+func TestClassifyCounters(t *testing.T) {
+	log := parallelTestLog(5000) + strings.Join(classifyTestLines, "\n") + "\n"
+	parse := func(classify func(string) postfixLine) string {
+		PostfixParserInit(&Config{cmd: "file"})
+		for _, line := range strings.SplitAfter(log, "\n") {
+			applyPostfixLine(line, classify(line))
+		}
+		return PostfixStats()
+	}
+	if got, want := parse(classifyPostfixLine), parse(classifyPostfixLineRegex); got != want {
+		t.Errorf("counters differ:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func FuzzClassifyPostfixLine(f *testing.F) {
+	for _, line := range classifyTestLines {
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		if got, want := classifyPostfixLine(line), classifyPostfixLineRegex(line); got != want {
+			t.Errorf("%q: got %+v, want %+v", line, got, want)
+		}
+	})
+}
+
+func benchmarkClassify(b *testing.B, classify func(string) postfixLine) {
+	lines := strings.SplitAfter(parallelTestLog(1000), "\n")
+	var size int64
+	for _, line := range lines {
+		size += int64(len(line))
+	}
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, line := range lines {
+			classify(line)
+		}
+	}
+}
+
+func BenchmarkClassifyPostfixLine(b *testing.B) {
+	benchmarkClassify(b, classifyPostfixLine)
+}
+
+func BenchmarkClassifyPostfixLineRegex(b *testing.B) {
+	benchmarkClassify(b, classifyPostfixLineRegex)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// fileConfig is the JSON configuration file set by the -c option.
+// Everything that does not fit on the command line lives here.
+type fileConfig struct {
+	Alerts        []*AlertRule         `json:"alerts"`
+	Senders       *SendersConfig       `json:"senders"`
+	Counters      []*CustomCounter     `json:"counters"`
+	Relays        *RelaysConfig        `json:"relays"`
+	ContentFilter *ContentFilterConfig `json:"content_filter"`
+}
+
+// jsonDuration is a time.Duration written as "5m" in the config file
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"5m\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(v)
+	return nil
+}
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// loadConfigFile reads the configuration file and checks its sections
+func loadConfigFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Cannot read config file: %v", err)
+	}
+
+	var fc fileConfig
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return fmt.Errorf("Cannot parse config file %s: %v", path, err)
+	}
+
+	for _, rule := range fc.Alerts {
+		if err := rule.check(); err != nil {
+			return fmt.Errorf("Alert %q: %v", rule.Name, err)
+		}
+		if rule.Window != 0 && cfg.historyRetention == 0 {
+			return fmt.Errorf("Alert %q: window needs the counter history, it is disabled by -history 0", rule.Name)
+		}
+	}
+	cfg.alertRules = fc.Alerts
+
+	if fc.Senders != nil {
+		for _, a := range fc.Senders.Actions {
+			if err := a.check(); err != nil {
+				return fmt.Errorf("Senders: %v", err)
+			}
+		}
+		cfg.senders = *fc.Senders
+	}
+	if fc.Relays != nil {
+		if fc.Relays.MaxFailureRatio < 0 || fc.Relays.MaxFailureRatio > 1 {
+			return fmt.Errorf("Relays: max_failure_ratio should be between 0 and 1")
+		}
+		for _, a := range fc.Relays.Actions {
+			if err := a.check(); err != nil {
+				return fmt.Errorf("Relays: %v", err)
+			}
+		}
+		cfg.relays = *fc.Relays
+	}
+	if fc.ContentFilter != nil {
+		cfg.contentFilter = *fc.ContentFilter
+	}
+	return setCustomCounters(fc.Counters)
+}
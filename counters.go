@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	maxLabelValues   = 100     // distinct label values kept per labeled counter
+	labelValueOthers = "other" // label value for everything over the limit
+	labelValueSep    = ","     // separates values of a counter with several labels
+)
+
+// counterGroup is a set of counters shown after the main Postfix ones
+// in stats, JSON and metrics outputs
+type counterGroup struct {
+	name    string           // group name, e.g. "postscreen"
+	names   []string         // plain counters, e.g. "postscreen-connect"
+	gauges  []string         // values which are not reset, e.g. "smtpd-sessions"
+	labeled []labeledCounter // counters split by a label value
+}
+
+// labeledCounter is a counter with values per label, e.g. DNSBL rank
+type labeledCounter struct {
+	name   string    // counter name, e.g. "postscreen-dnsbl-rank"
+	label  string    // label name for metrics, e.g. "rank", or several ones joined by labelValueSep
+	bounds []float64 // upper bounds of histogram buckets labeled by histogramLabel, a histogram in metrics
+}
+
+// GroupStats is a counter group in the JSON output
+type GroupStats struct {
+	Counters map[string]uint64            `json:"counters"`
+	Gauges   map[string]uint64            `json:"gauges,omitempty"`
+	Labeled  map[string]map[string]uint64 `json:"labeled,omitempty"`
+}
+
+// counterGroups lists all the counter groups in the order of output
+var counterGroups = []*counterGroup{
+	&postscreenCounters,
+	&smtpdCounters,
+	&tlsCounters,
+	&saslCounters,
+	&dsnCounters,
+	&transportCounters,
+	&filterCounters,
+}
+
+// counterNameValid checks if a single counter can be requested by name
+func counterNameValid(name string) bool {
+	if strArrayLookup(PostfixStatusNames[:], name) {
+		return true
+	}
+	for _, g := range counterGroups {
+		if strArrayLookup(g.names, name) || strArrayLookup(g.gauges, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// allCounterNames returns names of all the plain counters
+func allCounterNames() []string {
+	res := append([]string{}, PostfixStatusNames[:]...)
+	for _, g := range counterGroups {
+		res = append(res, g.names...)
+		res = append(res, g.gauges...)
+	}
+	return res
+}
+
+// value returns a counter or a gauge value, the caller should hold the lock
+func (c *MsgStatusCountersType) value(name string) uint64 {
+	if v, ok := c.gauges[name]; ok {
+		return v
+	}
+	return c.counters[name]
+}
+
+// incLabel adds v to a labeled counter, the caller should hold the lock.
+// The number of label values is limited, the rest is counted as "other".
+func (c *MsgStatusCountersType) incLabel(name, label string, v uint64) {
+	c.incLabelMax(name, label, v, maxLabelValues)
+}
+
+// incLabelMax is incLabel with the limit of label values
+func (c *MsgStatusCountersType) incLabelMax(name, label string, v uint64, limit int) {
+	for _, m := range []map[string]map[string]uint64{c.labeled, c.labeledTotals} {
+		values, ok := m[name]
+		if !ok {
+			values = make(map[string]uint64)
+			m[name] = values
+		}
+		l := label
+		if _, ok := values[l]; !ok && len(values) >= limit {
+			l = labelValueOthers
+		}
+		values[l] += v
+	}
+}
+
+// histogramLabel returns the label of the histogram bucket of v:
+// "<=0" for the first bound, "2..5" for 2 < v <= 5 and ">20" over the last bound
+func histogramLabel(bounds []float64, v float64) string {
+	format := func(b float64) string { return strconv.FormatFloat(b, 'f', -1, 64) }
+	for i, b := range bounds {
+		if v <= b {
+			if i == 0 {
+				return "<=" + format(b)
+			}
+			return format(bounds[i-1]) + ".." + format(b)
+		}
+	}
+	return ">" + format(bounds[len(bounds)-1])
+}
+
+// histogramBuckets returns the cumulative counts of the buckets of a
+// histogram labeled counter, the last one is +Inf
+func histogramBuckets(bounds []float64, values map[string]uint64) []uint64 {
+	res := make([]uint64, 0, len(bounds)+1)
+	var sum uint64
+	for _, b := range append(bounds[:len(bounds):len(bounds)], math.Inf(1)) {
+		sum += values[histogramLabel(bounds, b)]
+		res = append(res, sum)
+	}
+	return res
+}
+
+// groupsString returns the counter groups in the stats output format,
+// the caller should hold the lock
+func (c *MsgStatusCountersType) groupsString() string {
+	var res string
+	for _, g := range counterGroups {
+		for _, name := range g.names {
+			res += groupStatsLine(name, c.counters[name])
+		}
+		for _, name := range g.gauges {
+			res += groupStatsLine(name, c.gauges[name])
+		}
+		for _, lc := range g.labeled {
+			values := c.labeled[lc.name]
+			for _, label := range sortedKeys(values) {
+				res += groupStatsLine(lc.name+"["+label+"]", values[label])
+			}
+		}
+	}
+	return res
+}
+
+// groupStatsLine formats a counter as "name value", names are aligned
+// but always separated from values
+func groupStatsLine(name string, v uint64) string {
+	return fmt.Sprintf("%-*s%d\n", max(24, len(name)+1), name, v)
+}
+
+// groupsJSON returns the counter groups for the JSON output, the caller
+// should hold the lock
+func (c *MsgStatusCountersType) groupsJSON() map[string]GroupStats {
+	res := make(map[string]GroupStats, len(counterGroups))
+	for _, g := range counterGroups {
+		gs := GroupStats{Counters: make(map[string]uint64, len(g.names))}
+		for _, name := range g.names {
+			gs.Counters[name] = c.counters[name]
+		}
+		for _, name := range g.gauges {
+			if gs.Gauges == nil {
+				gs.Gauges = make(map[string]uint64, len(g.gauges))
+			}
+			gs.Gauges[name] = c.gauges[name]
+		}
+		for _, lc := range g.labeled {
+			if gs.Labeled == nil {
+				gs.Labeled = make(map[string]map[string]uint64)
+			}
+			values := make(map[string]uint64, len(c.labeled[lc.name]))
+			for k, v := range c.labeled[lc.name] {
+				values[k] = v
+			}
+			gs.Labeled[lc.name] = values
+		}
+		res[g.name] = gs
+	}
+	return res
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var reCustomCounterName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// CustomCounter is a counter of log lines matching a regexp, it is set
+// in the "counters" section of the config file
+type CustomCounter struct {
+	Name      string   `json:"name"`
+	Daemon    string   `json:"daemon"`     // e.g. "cleanup", any daemon if empty
+	Regexp    string   `json:"regexp"`     // matched to the text after "daemon[pid]: "
+	Labels    []string `json:"labels"`     // named groups of the regexp
+	MaxLabels int      `json:"max_labels"` // distinct label values kept, maxLabelValues if 0
+
+	re     *regexp.Regexp
+	groups []int // submatch indexes of the labels
+}
+
+// labeledName is the name of the counter split by the label values,
+// "milter-reject" with the "reason" label is "milter-reject-reason"
+func (c *CustomCounter) labeledName() string {
+	return c.Name + "-" + strings.Join(c.Labels, "-")
+}
+
+func (c *CustomCounter) check() error {
+	if !reCustomCounterName.MatchString(c.Name) {
+		return fmt.Errorf("name should consist of lowercase letters, digits and \"-\"")
+	}
+	if strArrayLookup(strings.Split(cmdAllowed, "|"), c.Name) {
+		return fmt.Errorf("name cannot be a command, it is one of %q", cmdAllowed)
+	}
+	var err error
+	if c.re, err = regexp.Compile(c.Regexp); err != nil {
+		return err
+	}
+	c.groups = nil
+	for _, label := range c.Labels {
+		i := c.re.SubexpIndex(label)
+		if i < 0 || !reMetricLabel.MatchString(label) {
+			return fmt.Errorf("label %q is not a named group of the regexp", label)
+		}
+		c.groups = append(c.groups, i)
+	}
+	if c.MaxLabels < 0 {
+		return fmt.Errorf("max_labels cannot be negative")
+	} else if c.MaxLabels == 0 {
+		c.MaxLabels = maxLabelValues
+	}
+	return nil
+}
+
+// customCounters is the group of counters set in the config file, it
+// is listed in counterGroups only if there are any
+var (
+	customCounters     = counterGroup{name: "custom"}
+	customCounterRules []*CustomCounter
+)
+
+// setCustomCounters checks the counters and makes them known along with
+// the built-in ones
+func setCustomCounters(rules []*CustomCounter) error {
+	known := make(map[string]bool)
+	for _, name := range allCounterNames() {
+		known[name] = true
+	}
+	for _, g := range counterGroups {
+		for _, lc := range g.labeled {
+			known[lc.name] = true
+		}
+	}
+	if len(customCounterRules) > 0 { // the previous custom counters are replaced
+		for _, name := range customCounters.names {
+			delete(known, name)
+		}
+		for _, lc := range customCounters.labeled {
+			delete(known, lc.name)
+		}
+	}
+
+	group := counterGroup{name: customCounters.name}
+	for _, c := range rules {
+		if err := c.check(); err != nil {
+			return fmt.Errorf("Counter %q: %v", c.Name, err)
+		}
+		names := []string{c.Name}
+		if len(c.Labels) > 0 {
+			names = append(names, c.labeledName())
+		}
+		for _, name := range names {
+			if known[name] {
+				return fmt.Errorf("Counter %q: %q is already defined", c.Name, name)
+			}
+			known[name] = true
+		}
+		group.names = append(group.names, c.Name)
+		if len(c.Labels) > 0 {
+			group.labeled = append(group.labeled,
+				labeledCounter{name: c.labeledName(), label: strings.Join(c.Labels, labelValueSep)})
+		}
+	}
+
+	customCounters, customCounterRules = group, rules
+	groups := counterGroups[:0:0]
+	for _, g := range counterGroups {
+		if g != &customCounters {
+			groups = append(groups, g)
+		}
+	}
+	if len(rules) > 0 {
+		groups = append(groups, &customCounters)
+	}
+	counterGroups = groups
+	return nil
+}
+
+// customLineParse counts a line by the custom counters, s is the rest
+// of the line after "postfix/". A line may be counted by several ones.
+func customLineParse(s string) {
+	if len(customCounterRules) == 0 {
+		return
+	}
+	daemon, msg, ok := postfixDaemon(strings.TrimRight(s, "\r\n"))
+	if !ok {
+		return
+	}
+
+	msgStatusCounters.lock()
+	defer msgStatusCounters.unlock()
+	for _, c := range customCounterRules {
+		if c.Daemon != "" && c.Daemon != daemon {
+			continue
+		}
+		if len(c.groups) == 0 {
+			if c.re.MatchString(msg) {
+				msgStatusCounters.inc(c.Name, 1)
+			}
+			continue
+		}
+		sMatch := c.re.FindStringSubmatch(msg)
+		if sMatch == nil {
+			continue
+		}
+		values := make([]string, len(c.groups))
+		for i, g := range c.groups {
+			values[i] = strings.ReplaceAll(sMatch[g], labelValueSep, ";")
+		}
+		msgStatusCounters.inc(c.Name, 1)
+		msgStatusCounters.incLabelMax(c.labeledName(), strings.Join(values, labelValueSep), 1, c.MaxLabels)
+	}
+}
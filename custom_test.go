@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCustomCounters(t *testing.T) {
+	var rules []*CustomCounter
+	err := json.Unmarshal([]byte(`[
+		{"name": "milter-reject", "daemon": "cleanup", "regexp": "milter-reject: \\S+ from (?P<client>\\S+?)\\[",
+		 "labels": ["client"], "max_labels": 2},
+		{"name": "header-warning", "regexp": "^warning: header (?P<header>\\S+): .* proto=(?P<proto>\\w+)",
+		 "labels": ["header", "proto"]},
+		{"name": "policy-timeout", "daemon": "smtpd", "regexp": "problem talking to server private/policy"}
+	]`), &rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setCustomCounters(rules); err != nil {
+		t.Fatal(err)
+	}
+	defer setCustomCounters(nil)
+	PostfixParserInit(&Config{cmd: "file"})
+
+	prefix := "Apr  1 00:00:00 mail postfix/"
+	lines := []string{
+		"cleanup[1]: 0A2D132D5F: milter-reject: END-OF-MESSAGE from a.example.com[192.0.2.1]: 5.7.1 Spam; from=<a@b.c>\n",
+		"cleanup[1]: 0A2D132D5F: milter-reject: END-OF-MESSAGE from b.example.com[192.0.2.2]: 5.7.1 Spam; from=<a@b.c>",
+		"cleanup[1]: 0A2D132D5F: milter-reject: END-OF-MESSAGE from c.example.com[192.0.2.3]: 5.7.1 Spam; from=<a@b.c>",
+		"cleanup[1]: 0A2D132D5F: milter-reject: END-OF-MESSAGE from a.example.com[192.0.2.1]: 5.7.1 Spam; from=<a@b.c>",
+		"smtpd[2]: milter-reject: END-OF-MESSAGE from a.example.com[192.0.2.1]: wrong daemon",
+		"cleanup[1]: warning: header Subject: buy now from x[192.0.2.1]; from=<a@b.c> proto=ESMTP",
+		"cleanup[1]: warning: header X-Mailer: a,b from x[192.0.2.1]; from=<a@b.c> proto=ESMTP",
+		"smtpd[2]: warning: problem talking to server private/policy: Connection timed out",
+		"smtpd[2]: connect from unknown[192.0.2.1]",
+	}
+	for _, l := range lines {
+		PostfixLineParse(prefix + l)
+	}
+
+	want := map[string]uint64{"milter-reject": 4, "header-warning": 2, "policy-timeout": 1, "smtpd-connect": 1}
+	for name, v := range want {
+		if got := msgStatusCounters.value(name); got != v {
+			t.Errorf("Expected %s=%d, got %d", name, v, got)
+		}
+	}
+	if m := msgStatusCounters.labeled["milter-reject-client"]; len(m) != 3 || m["a.example.com"] != 2 || m["b.example.com"] != 1 || m[labelValueOthers] != 1 {
+		t.Errorf("unexpected milter-reject-client %v", m)
+	}
+
+	stats := PostfixStats()
+	for _, s := range []string{"\nmilter-reject           4\n", "\nheader-warning-header-proto[Subject,ESMTP] 1\n"} {
+		if !strings.Contains(stats, s) {
+			t.Errorf("stats do not contain %q:\n%s", s, stats)
+		}
+	}
+	metrics := metricsString()
+	for _, s := range []string{
+		"\nmlogtail_policy_timeout_total 1\n",
+		"\nmlogtail_milter_reject_client_total{client=\"other\"} 1\n",
+		"\nmlogtail_header_warning_header_proto_total{header=\"X-Mailer\",proto=\"ESMTP\"} 1\n",
+	} {
+		if !strings.Contains(metrics, s) {
+			t.Errorf("metrics do not contain %q:\n%s", s, metrics)
+		}
+	}
+
+	if !counterNameValid("milter-reject") {
+		t.Error("custom counter is not valid")
+	}
+	rr := httptest.NewRecorder()
+	handleCounter(rr, httptest.NewRequest("GET", "/counter/policy-timeout", nil))
+	if !strings.Contains(rr.Body.String(), `"value":1`) {
+		t.Errorf("unexpected /counter/policy-timeout response %s", rr.Body.String())
+	}
+}
+
+func TestCustomCountersCheck(t *testing.T) {
+	defer setCustomCounters(nil)
+	for _, c := range []CustomCounter{
+		{Name: "Bad name", Regexp: "x"},
+		{Name: "x", Regexp: "("},
+		{Name: "x", Regexp: "(?P<a>x)", Labels: []string{"b"}},
+		{Name: "x", Regexp: "x", MaxLabels: -1},
+		{Name: "received", Regexp: "x"},
+		{Name: "sasl-success-method", Regexp: "x"},
+		{Name: "sasl", Regexp: "(?P<success>x)", Labels: []string{"success"}},
+		{Name: "top", Regexp: "x"},
+		{Name: "report", Regexp: "x"},
+	} {
+		if err := setCustomCounters([]*CustomCounter{&c}); err == nil {
+			t.Errorf("no error for %+v", c)
+		}
+	}
+	for _, name := range []string{"re", "eset", "at", "ail"} { // parts of commands are fine
+		if err := setCustomCounters([]*CustomCounter{{Name: name, Regexp: "x"}}); err != nil {
+			t.Errorf("name %q: %v", name, err)
+		}
+	}
+	a, b := &CustomCounter{Name: "x", Regexp: "x"}, &CustomCounter{Name: "x", Regexp: "y"}
+	if err := setCustomCounters([]*CustomCounter{a, b}); err == nil {
+		t.Error("no error for duplicate names")
+	}
+	if err := setCustomCounters([]*CustomCounter{a}); err != nil {
+		t.Error(err)
+	}
+	if err := setCustomCounters([]*CustomCounter{a}); err != nil { // replaced, not duplicated
+		t.Error(err)
+	}
+	if n := len(counterGroups); counterGroups[n-1] != &customCounters {
+		t.Error("custom counters are not listed")
+	}
+	setCustomCounters(nil)
+	for _, g := range counterGroups {
+		if g == &customCounters {
+			t.Error("empty custom counters are listed")
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var dovecotCounters = counterGroup{
+	name: "dovecot",
+	names: []string{"dovecot-imap-login", "dovecot-pop3-login",
+		"dovecot-imap-login-failed", "dovecot-pop3-login-failed",
+		"dovecot-imap-disconnect", "dovecot-pop3-disconnect",
+		"dovecot-lmtp-saved", "dovecot-quota-exceeded", "dovecot-auth-failed"},
+	labeled: []labeledCounter{
+		{name: "dovecot-login-failed-reason", label: "reason"},
+		{name: "dovecot-login-disconnect-reason", label: "reason"},
+		{name: "dovecot-auth-failed-method", label: "method"},
+	},
+}
+
+var dovecotModule = logModule{name: "dovecot", group: &dovecotCounters, parse: dovecotLineParse}
+
+// " in 2 secs" and the like at the end of a login failure reason
+var reDovecotReasonTime = regexp.MustCompile(` in \d+ secs$`)
+
+// dovecotNoLoginReasons are disconnects of clients which did not try to
+// log in, e.g. idle connections and port scans
+var dovecotNoLoginReasons = []string{"no auth attempts", "disconnected before auth was ready",
+	"disconnected before greeting"}
+
+// dovecotLineParse counts IMAP and POP3 logins
+// "imap-login: Login: user=<b@example.com>, method=PLAIN, rip=192.0.2.7, ...",
+// failed ones "imap-login: Disconnected (auth failed, 1 attempts in 2 secs): user=<b@example.com>, method=PLAIN, ...",
+// disconnects "imap(b@example.com)<1234><abc>: Disconnected: Logged out in=100 out=2000",
+// LMTP deliveries "lmtp(b@example.com)<1234><abc>: msgid=<1@example.net>: saved mail to INBOX"
+// and quota errors "... save failed to INBOX: Quota exceeded (mailbox for user is full)"
+func dovecotLineParse(prefix, tag, msg string) {
+	if tag != "dovecot" {
+		return
+	}
+	service, rest, ok := strings.Cut(msg, ": ")
+	if !ok {
+		return
+	}
+	user := strings.IndexByte(service, '(') >= 0 // a process of a logged in user
+	if i := strings.IndexAny(service, "(<"); i >= 0 {
+		service = service[:i]
+	}
+
+	msgStatusCounters.lock()
+	defer msgStatusCounters.unlock()
+	switch service {
+	case "imap-login", "pop3-login":
+		protocol := strings.TrimSuffix(service, "-login")
+		if strings.HasPrefix(rest, "Login: ") {
+			msgStatusCounters.inc("dovecot-"+protocol+"-login", 1)
+			return
+		}
+		if !strings.HasPrefix(rest, "Disconnected") && !strings.HasPrefix(rest, "Aborted login") &&
+			!strings.HasPrefix(rest, "Login aborted") {
+			return
+		}
+		reason := dovecotLoginFailure(rest)
+		if reason == "" {
+			return
+		}
+		if strArrayLookup(dovecotNoLoginReasons, reason) {
+			msgStatusCounters.incLabel("dovecot-login-disconnect-reason", reason, 1)
+			return
+		}
+		msgStatusCounters.inc("dovecot-"+protocol+"-login-failed", 1)
+		msgStatusCounters.incLabel("dovecot-login-failed-reason", reason, 1)
+		if strings.HasPrefix(reason, "auth failed") {
+			msgStatusCounters.inc("dovecot-auth-failed", 1)
+			if method := logField(rest, "method"); method != "" {
+				msgStatusCounters.incLabel("dovecot-auth-failed-method", method, 1)
+			}
+		}
+	case "imap", "pop3":
+		if user && strings.HasPrefix(rest, "Disconnected") {
+			msgStatusCounters.inc("dovecot-"+service+"-disconnect", 1)
+		}
+	case "lmtp", "lda":
+		if strings.Contains(rest, "Quota exceeded") {
+			msgStatusCounters.inc("dovecot-quota-exceeded", 1)
+		} else if strings.Contains(rest, ": saved mail to ") || strings.Contains(rest, ": stored mail into mailbox ") {
+			msgStatusCounters.inc("dovecot-lmtp-saved", 1)
+		}
+	}
+}
+
+// dovecotLoginFailure returns the reason of a login process disconnect
+// "Disconnected (auth failed, 1 attempts in 2 secs): user=<...>" as
+// "auth failed", or "" if there is no reason
+func dovecotLoginFailure(s string) string {
+	if i := strings.Index(s, ": user=<"); i >= 0 {
+		s = s[:i]
+	}
+	i := strings.IndexByte(s, '(')
+	if i < 0 {
+		return ""
+	}
+	j := strings.IndexByte(s[i:], ')')
+	if j < 0 {
+		return ""
+	}
+	reason, _, _ := strings.Cut(s[i+1:i+j], ",")
+	return reDovecotReasonTime.ReplaceAllString(reason, "")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDovecotLineParse(t *testing.T) {
+	if err := setLogTypes("postfix,dovecot"); err != nil {
+		t.Fatal(err)
+	}
+	defer setLogTypes("postfix")
+	PostfixParserInit(&Config{cmd: "file"})
+
+	prefix := "Apr  1 10:00:00 mail "
+	lines := []string{
+		"dovecot: imap-login: Login: user=<b@example.com>, method=PLAIN, rip=192.0.2.7, lip=192.0.2.1, mpid=1234, TLS, session=<abc>",
+		"dovecot[100]: imap-login: Login: user=<c@example.com>, method=LOGIN, rip=192.0.2.8, lip=192.0.2.1, mpid=1235, TLS, session=<abd>\n",
+		"dovecot: pop3-login: Login: user=<b@example.com>, method=PLAIN, rip=192.0.2.7, lip=192.0.2.1, mpid=1236, TLS, session=<abe>",
+		"dovecot: imap-login: Disconnected (auth failed, 1 attempts in 2 secs): user=<b@example.com>, method=PLAIN, rip=192.0.2.9, lip=192.0.2.1, TLS, session=<abf>",
+		"dovecot: imap-login: Aborted login (auth failed, 3 attempts in 12 secs): user=<admin>, method=LOGIN, rip=192.0.2.9, lip=192.0.2.1, session=<abg>",
+		"dovecot: pop3-login: Login aborted: Connection closed (auth failed, 1 attempts in 2 secs) (auth_failed): user=<b@example.com>, method=PLAIN, rip=192.0.2.9, lip=192.0.2.1, session=<abh>",
+		"dovecot: imap-login: Disconnected: Inactivity (no auth attempts in 180 secs): user=<>, rip=192.0.2.10, lip=192.0.2.1, session=<abi>",
+		"dovecot: pop3-login: Disconnected (tried to use disallowed plaintext auth): user=<>, rip=192.0.2.11, lip=192.0.2.1, session=<abj>",
+		"dovecot: imap-login: Disconnected (disconnected before auth was ready, waited 0 secs): user=<>, rip=192.0.2.12, lip=192.0.2.1, session=<abk>",
+		"dovecot: imap(b@example.com)<1234><abc>: Disconnected: Logged out in=100 out=2000 deleted=0 expunged=0 trashed=0 hdr_count=0 hdr_bytes=0 body_count=0 body_bytes=0",
+		"dovecot: imap(c@example.com)<1235><abd>: Disconnected for inactivity in=50 out=600",
+		"dovecot: pop3(b@example.com)<1236><abe>: Disconnected: Logged out top=0/0, retr=1/1000, del=1/1, size=1000",
+		"dovecot: imap(b@example.com)<1234><abc>: Connection closed (IDLE running for 0.001 + waiting input for 28.999 secs) in=10 out=20",
+		"dovecot: lmtp(b@example.com)<2000><xyz>: msgid=<1@example.net>: saved mail to INBOX",
+		"dovecot: lmtp(c@example.com)<2000><xyz>: sieve: msgid=<2@example.net>: stored mail into mailbox 'Junk'",
+		"dovecot: lda(d@example.com)<2001><xya>: msgid=<3@example.net>: save failed to INBOX: Quota exceeded (mailbox for user is full)",
+		"dovecot: lmtp(2000): Connect from local",
+		"dovecot: auth: passwd-file(admin,192.0.2.9): unknown user",
+		"postfix/smtpd[1]: connect from x[192.0.2.1]",
+	}
+	for _, l := range lines {
+		PostfixLineParse(prefix + l)
+	}
+
+	want := map[string]uint64{
+		"dovecot-imap-login": 2, "dovecot-pop3-login": 1,
+		"dovecot-imap-login-failed": 2, "dovecot-pop3-login-failed": 2,
+		"dovecot-imap-disconnect": 2, "dovecot-pop3-disconnect": 1,
+		"dovecot-lmtp-saved": 2, "dovecot-quota-exceeded": 1, "dovecot-auth-failed": 3,
+	}
+	for name, v := range want {
+		if got := msgStatusCounters.counters[name]; got != v {
+			t.Errorf("Expected %s=%d, got %d", name, v, got)
+		}
+	}
+	labeled := map[string]map[string]uint64{
+		"dovecot-login-failed-reason":     {"auth failed": 3, "tried to use disallowed plaintext auth": 1},
+		"dovecot-login-disconnect-reason": {"disconnected before auth was ready": 1, "no auth attempts": 1},
+		"dovecot-auth-failed-method":      {"PLAIN": 2, "LOGIN": 1},
+	}
+	for name, want := range labeled {
+		if got := msgStatusCounters.labeled[name]; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %s=%v, got %v", name, want, got)
+		}
+	}
+	if stats := PostfixStats(); !strings.Contains(stats, "\ndovecot-auth-failed-method[PLAIN]") {
+		t.Errorf("unexpected stats:\n%s", stats)
+	}
+}
+
+func TestDovecotLoginFailure(t *testing.T) {
+	for s, want := range map[string]string{
+		"Disconnected (auth failed, 1 attempts in 2 secs): user=<b@example.com>":    "auth failed",
+		"Disconnected (no auth attempts in 0 secs): user=<>, rip=192.0.2.1":         "no auth attempts",
+		"Disconnected (disconnected before auth was ready, waited 0 secs): user=<>": "disconnected before auth was ready",
+		"Disconnected: Connection closed: user=<(x)>, rip=192.0.2.1":                "",
+		"Disconnected (auth failed": "",
+	} {
+		if got := dovecotLoginFailure(s); got != want {
+			t.Errorf("dovecotLoginFailure(%q) = %q, expected %q", s, got, want)
+		}
+	}
+}
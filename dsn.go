@@ -0,0 +1,35 @@
+package main
+
+import (
+	"regexp"
+)
+
+const maxDSNCodes = 50 // distinct DSN codes kept per status, the rest is "other"
+
+var reDSN = regexp.MustCompile(`^([245]\.\d{1,3})\.\d{1,3}$`)
+
+// dsnCounters split deferred and bounced deliveries by the DSN code
+// ("4.4.1") and by its class and subject ("4.4")
+var dsnCounters = counterGroup{
+	name: "dsn",
+	labeled: []labeledCounter{
+		{name: "deferred-dsn", label: "dsn"},
+		{name: "deferred-dsn-class", label: "class"},
+		{name: "bounced-dsn", label: "dsn"},
+		{name: "bounced-dsn-class", label: "class"},
+	},
+}
+
+// dsnLineParse counts the DSN code of a delivery line
+// "QID: to=<x@example.com>, relay=..., dsn=4.4.1, status=deferred (...)",
+// status is "deferred" or "bounced"
+func dsnLineParse(status, msg string) {
+	sMatch := reDSN.FindStringSubmatch(logField(msg, "dsn"))
+	if sMatch == nil {
+		return
+	}
+	msgStatusCounters.lock()
+	msgStatusCounters.incLabelMax(status+"-dsn", sMatch[0], 1, maxDSNCodes)
+	msgStatusCounters.incLabelMax(status+"-dsn-class", sMatch[1], 1, maxDSNCodes)
+	msgStatusCounters.unlock()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDSNLineParse(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	prefix := "Apr  1 10:00:00 mail postfix/"
+	lines := []string{
+		"smtp[200]: 0A2D132D5F: to=<a@example.com>, relay=mx.example.com[198.51.100.1]:25, delay=1, dsn=4.7.0, status=deferred (host said: 450 4.7.0 greylisted)",
+		"smtp[200]: 0A2D132D5F: to=<a@example.com>, relay=mx.example.com[198.51.100.1]:25, delay=1, dsn=4.7.1, status=deferred (host said: 450 4.7.1 try later)",
+		"smtp[200]: 0A2D132D5F: to=<a@example.com>, relay=none, delay=30, dsn=4.4.1, status=deferred (connect to mx.example.com[198.51.100.1]:25: Connection timed out)\n",
+		"smtp[201]: 1B2D132D5F: to=<b@example.net>, relay=mx.example.net[198.51.100.2]:25, delay=1, dsn=5.1.1, status=bounced (host said: 550 5.1.1 user unknown)",
+		"smtp[202]: 2C2D132D5F: to=<c@example.net>, relay=mx.example.net[198.51.100.2]:25, delay=1, dsn=2.0.0, status=sent (250 Ok)",
+		"smtp[203]: 3D2D132D5F: to=<d@example.net>, relay=none, delay=1, dsn=x.y.z, status=bounced (bad)",
+	}
+	for _, l := range lines {
+		PostfixLineParse(prefix + l)
+	}
+
+	labeled := map[string]map[string]uint64{
+		"deferred-dsn":       {"4.7.0": 1, "4.7.1": 1, "4.4.1": 1},
+		"deferred-dsn-class": {"4.7": 2, "4.4": 1},
+		"bounced-dsn":        {"5.1.1": 1},
+		"bounced-dsn-class":  {"5.1": 1},
+	}
+	for name, want := range labeled {
+		if got := msgStatusCounters.labeled[name]; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %s=%v, got %v", name, want, got)
+		}
+	}
+	if got := msgStatusCounters.counters["bounced"]; got != 2 {
+		t.Errorf("Expected bounced=2, got %d", got)
+	}
+
+	gs := msgStatusCounters.groupsJSON()["dsn"]
+	if gs.Labeled["deferred-dsn"]["4.4.1"] != 1 {
+		t.Errorf("unexpected JSON %+v", gs)
+	}
+	if m := metricsString(); !strings.Contains(m, "\nmlogtail_deferred_dsn_class_total{class=\"4.7\"} 2\n") {
+		t.Errorf("unexpected metrics:\n%s", m)
+	}
+
+	// codes over the limit are "other"
+	for i := 0; i < maxDSNCodes+5; i++ {
+		PostfixLineParse(prefix + fmt.Sprintf("smtp[204]: 4E2D132D5F: to=<e@example.net>, dsn=5.%d.1, status=bounced (x)", i))
+	}
+	if m := msgStatusCounters.labeled["bounced-dsn"]; len(m) != maxDSNCodes+1 || m[labelValueOthers] == 0 {
+		t.Errorf("bounced-dsn is not limited: %d codes", len(m))
+	}
+}
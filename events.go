@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const eventsClientBuffer = 64 // events queued for a client before we start dropping
+
+// PostfixEvent is a single classified log line as PostfixLineParse
+// recognises it. It is sent to /events?raw=1 subscribers.
+type PostfixEvent struct {
+	Type     string `json:"type"`
+	QueueID  string `json:"queue_id,omitempty"`
+	Identity string `json:"identity,omitempty"` // sender or SASL username over the limit
+	Relay    string `json:"relay,omitempty"`    // relay starting or stopping to fail
+}
+
+// eventBroker fans counter updates and raw events out to SSE clients.
+// Sending never blocks: if a client does not read fast enough its
+// events are dropped.
+type eventBroker struct {
+	sync.Mutex
+	interval   time.Duration
+	statsSubs  map[chan []byte]bool
+	rawSubs    map[chan []byte]bool
+	lastStats  []byte
+	rawEnabled bool // there is at least one raw subscriber
+}
+
+var events *eventBroker // nil unless the HTTP server is enabled
+
+func newEventBroker(interval time.Duration) *eventBroker {
+	return &eventBroker{
+		interval:  interval,
+		statsSubs: make(map[chan []byte]bool),
+		rawSubs:   make(map[chan []byte]bool),
+	}
+}
+
+// publish is called by the parser for every classified line.
+func (b *eventBroker) publish(ev PostfixEvent) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	if !b.rawEnabled {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	for ch := range b.rawSubs {
+		sendNonBlocking(ch, data)
+	}
+}
+
+// run checks the counters every interval and pushes a snapshot to the
+// stats subscribers if anything has changed since the last push.
+func (b *eventBroker) run() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.pushStats()
+	}
+}
+
+func (b *eventBroker) pushStats() {
+	data, err := json.Marshal(getCountersJSON())
+	if err != nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if string(data) == string(b.lastStats) {
+		return
+	}
+	b.lastStats = data
+	for ch := range b.statsSubs {
+		sendNonBlocking(ch, data)
+	}
+}
+
+func (b *eventBroker) subscribe(raw bool) chan []byte {
+	ch := make(chan []byte, eventsClientBuffer)
+	b.Lock()
+	if raw {
+		b.rawSubs[ch] = true
+		b.rawEnabled = true
+	} else {
+		b.statsSubs[ch] = true
+		// a new client gets the current state straight away
+		if b.lastStats != nil {
+			ch <- b.lastStats
+		}
+	}
+	b.Unlock()
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan []byte) {
+	b.Lock()
+	delete(b.statsSubs, ch)
+	delete(b.rawSubs, ch)
+	b.rawEnabled = len(b.rawSubs) > 0
+	b.Unlock()
+}
+
+func sendNonBlocking(ch chan []byte, data []byte) {
+	select {
+	case ch <- data:
+	default: // slow client, drop the event
+	}
+}
+
+// handleEvents serves /events as a Server-Sent Events stream. By default
+// it sends counter snapshots, with ?raw=1 it sends classified events.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || events == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Event streaming is not supported"})
+		return
+	}
+
+	raw := r.URL.Query().Get("raw") == "1"
+	ch := events.subscribe(raw)
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-ch:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
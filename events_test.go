@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBrokerSlowClient(t *testing.T) {
+	b := newEventBroker(time.Second)
+	ch := b.subscribe(true)
+	defer b.unsubscribe(ch)
+
+	done := make(chan bool)
+	go func() {
+		// nobody reads ch, so publishing must drop instead of blocking
+		for i := 0; i < eventsClientBuffer*2; i++ {
+			b.publish(PostfixEvent{Type: "received", QueueID: "AD59432D65"})
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a slow client")
+	}
+	if len(ch) != eventsClientBuffer {
+		t.Errorf("expected %d queued events, got %d", eventsClientBuffer, len(ch))
+	}
+}
+
+func TestEventBrokerStatsOnChange(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	b := newEventBroker(time.Second)
+	ch := b.subscribe(false)
+	defer b.unsubscribe(ch)
+
+	b.pushStats()
+	b.pushStats() // nothing changed, must not be sent again
+	if len(ch) != 1 {
+		t.Fatalf("expected 1 stats event, got %d", len(ch))
+	}
+	<-ch
+
+	msgStatusCounters.counters["bounced"] = 3
+	b.pushStats()
+	if len(ch) != 1 {
+		t.Fatalf("expected stats event after a counter change, got %d", len(ch))
+	}
+	var stats StatsResponse
+	if err := json.Unmarshal(<-ch, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bounced != 3 {
+		t.Errorf("Expected bounced=3, got %d", stats.Bounced)
+	}
+}
+
+func TestHandleEventsRaw(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	events = newEventBroker(time.Second)
+	defer func() { events = nil }()
+
+	srv := httptest.NewServer(http.HandlerFunc(handleEvents))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events?raw=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+
+	PostfixLineParse("Jul 22 19:06:42 mail postfix/smtp[30345]: AD59432D65: to=<user@example.com>, relay=mx.example.com[1.2.3.4]:25, delay=1.5, dsn=5.1.1, status=bounced (user unknown)")
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `data: {"type":"bounced","queue_id":"AD59432D65"}`
+	if strings.TrimSpace(line) != want {
+		t.Errorf("got %q, want %q", strings.TrimSpace(line), want)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// default content filter relays, amavis and DKIM signing filters listen
+// on these loopback ports
+var defaultFilterRelays = []string{"127.0.0.1:10024", "[::1]:10024", "127.0.0.1:10026", "[::1]:10026"}
+
+// filterCounters split received and delivered messages and bytes into
+// external traffic and the content filter loop: delivered to the filter
+// and received back after the filtering
+var filterCounters = counterGroup{
+	name: "filter",
+	names: []string{"external-received", "external-delivered",
+		"bytes-external-received", "bytes-external-delivered",
+		"filter-received", "filter-delivered",
+		"bytes-filter-received", "bytes-filter-delivered"},
+}
+
+// ContentFilterConfig is the "content_filter" section of the config
+// file. Messages go through a filter like amavis twice: they are
+// delivered to it and then received again from it.
+type ContentFilterConfig struct {
+	Relays    []string `json:"relays"`           // "127.0.0.1:10024" or "host[ip]:port", defaultFilterRelays if empty
+	Smtpd     []string `json:"smtpd"`            // syslog names of smtpd receiving filtered mail, e.g. "postfix-reinject/smtpd"
+	Loopback  bool     `json:"loopback_clients"` // mail from 127.0.0.1 and ::1 comes from the filter
+	CountOnce bool     `json:"count_once"`       // main counters count a message only once
+}
+
+type contentFilterWatcher struct {
+	relays    map[string]bool
+	smtpd     map[string]bool
+	loopback  bool
+	countOnce bool
+}
+
+var contentFilter = newContentFilter(ContentFilterConfig{})
+
+func newContentFilter(cfg ContentFilterConfig) *contentFilterWatcher {
+	f := &contentFilterWatcher{relays: make(map[string]bool), smtpd: make(map[string]bool),
+		loopback: cfg.Loopback, countOnce: cfg.CountOnce}
+	if len(cfg.Relays) == 0 {
+		cfg.Relays = defaultFilterRelays
+	}
+	for _, r := range cfg.Relays {
+		f.relays[r] = true
+	}
+	for _, name := range cfg.Smtpd {
+		f.smtpd[name] = true
+	}
+	return f
+}
+
+// isFilterRelay checks relay "localhost[127.0.0.1]:10024" of a delivery line
+func (f *contentFilterWatcher) isFilterRelay(relay string) bool {
+	if f.relays[relay] {
+		return true
+	}
+	i := strings.IndexByte(relay, '[')
+	j := strings.LastIndex(relay, "]:")
+	if i < 0 || j < i {
+		return false
+	}
+	return f.relays[net.JoinHostPort(relay[i+1:j], relay[j+2:])]
+}
+
+// reinjected checks if a received line "QID: client=localhost[127.0.0.1], orig_queue_id=..."
+// is a message coming back from the filter. Postfix logs orig_queue_id
+// and orig_client if the filter passes them by XFORWARD, otherwise the
+// smtpd syslog name or loopback_clients should be set in the config
+// file. prefix is the syslog part of the line, s is the rest after "postfix/".
+func (f *contentFilterWatcher) reinjected(prefix, s string) bool {
+	if strings.Contains(s, ", orig_queue_id=") || strings.Contains(s, ", orig_client=") {
+		return true
+	}
+	if f.loopback && isLoopbackClient(logField(s, "client")) {
+		return true
+	}
+	if len(f.smtpd) == 0 {
+		return false
+	}
+	name := prefix[strings.LastIndexByte(strings.TrimSuffix(prefix, "/"), ' ')+1:]
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		name += s[:i]
+	}
+	return f.smtpd[name]
+}
+
+// isLoopbackClient checks a client "localhost[127.0.0.1]" of a received line
+func isLoopbackClient(client string) bool {
+	client = strings.TrimRight(client, "\r\n")
+	i := strings.IndexByte(client, '[')
+	if i < 0 || !strings.HasSuffix(client, "]") {
+		return false
+	}
+	ip := net.ParseIP(client[i+1 : len(client)-1])
+	return ip != nil && ip.IsLoopback()
+}
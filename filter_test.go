@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+)
+
+// filterTestLines is a message going through amavis and a local
+// message going out directly
+var filterTestLines = []string{
+	"smtpd[100]: 0A2D132D5F: client=mail.example.net[192.0.2.1]",
+	"qmgr[101]: 0A2D132D5F: from=<a@example.net>, size=1000, nrcpt=1 (queue active)",
+	"smtp[102]: 0A2D132D5F: to=<b@example.com>, relay=127.0.0.1[127.0.0.1]:10024, delay=1, dsn=2.0.0, status=sent (250 2.0.0 from MTA(smtp:[127.0.0.1]:10025): 250 2.0.0 Ok: queued as 1B2D132D5F)",
+	"qmgr[101]: 0A2D132D5F: removed",
+	"smtpd[103]: 1B2D132D5F: client=localhost[127.0.0.1], orig_queue_id=0A2D132D5F, orig_client=mail.example.net[192.0.2.1]",
+	"qmgr[101]: 1B2D132D5F: from=<a@example.net>, size=1500, nrcpt=1 (queue active)",
+	"lmtp[104]: 1B2D132D5F: to=<b@example.com>, relay=mail.example.com[private/dovecot-lmtp], delay=1, dsn=2.0.0, status=sent (250 2.0.0 Saved)",
+	"qmgr[101]: 1B2D132D5F: removed",
+	"pickup[105]: 2C2D132D5F: uid=1000 from=<c@example.com>",
+	"qmgr[101]: 2C2D132D5F: from=<c@example.com>, size=500, nrcpt=1 (queue active)",
+	"smtp[106]: 2C2D132D5F: to=<d@example.org>, relay=mx.example.org[198.51.100.1]:25, delay=1, dsn=2.0.0, status=sent (250 Ok)",
+	"qmgr[101]: 2C2D132D5F: removed",
+}
+
+func TestContentFilter(t *testing.T) {
+	for _, countOnce := range []bool{false, true} {
+		PostfixParserInit(&Config{cmd: "file", contentFilter: ContentFilterConfig{CountOnce: countOnce}})
+		for _, l := range filterTestLines {
+			PostfixLineParse("Apr  1 10:00:00 mail postfix/" + l)
+		}
+
+		want := map[string]uint64{
+			"received": 3, "delivered": 3, "bytes-received": 3000, "bytes-delivered": 3000,
+			"external-received": 2, "external-delivered": 2, "bytes-external-received": 1500, "bytes-external-delivered": 2000,
+			"filter-received": 1, "filter-delivered": 1, "bytes-filter-received": 1500, "bytes-filter-delivered": 1000,
+		}
+		if countOnce {
+			want["received"], want["delivered"], want["bytes-received"], want["bytes-delivered"] = 2, 2, 1500, 2000
+		}
+		for name, v := range want {
+			if got := msgStatusCounters.counters[name]; got != v {
+				t.Errorf("count_once=%v: expected %s=%d, got %d", countOnce, name, v, got)
+			}
+		}
+		if got := msgStatusCounters.labeled["delivered-transport"]["smtp"]; countOnce && got != 1 || !countOnce && got != 2 {
+			t.Errorf("count_once=%v: unexpected delivered-transport[smtp]=%d", countOnce, got)
+		}
+		if len(msgStatusCounters.filterRcvMap) != 0 || len(msgStatusCounters.newRcvMap) != 0 {
+			t.Errorf("count_once=%v: messages are left in maps", countOnce)
+		}
+	}
+	contentFilter = newContentFilter(ContentFilterConfig{})
+}
+
+// without XFORWARD the filter re-injects messages from localhost
+func TestContentFilterNoXforward(t *testing.T) {
+	defer func() { contentFilter = newContentFilter(ContentFilterConfig{}) }()
+	lines := append([]string{}, filterTestLines...)
+	lines[4] = "smtpd[103]: 1B2D132D5F: client=localhost[127.0.0.1]\n"
+	for _, loopback := range []bool{false, true} {
+		PostfixParserInit(&Config{cmd: "file", contentFilter: ContentFilterConfig{Loopback: loopback, CountOnce: true}})
+		for _, l := range lines {
+			PostfixLineParse("Apr  1 10:00:00 mail postfix/" + l)
+		}
+		want := map[string]uint64{
+			"received": 3, "delivered": 2, "external-received": 3, "filter-received": 0, "filter-delivered": 1,
+		}
+		if loopback { // a message is counted once
+			want["received"], want["external-received"], want["filter-received"] = 2, 2, 1
+		}
+		for name, v := range want {
+			if got := msgStatusCounters.counters[name]; got != v {
+				t.Errorf("loopback_clients=%v: expected %s=%d, got %d", loopback, name, v, got)
+			}
+		}
+	}
+}
+
+func TestContentFilterDetection(t *testing.T) {
+	f := newContentFilter(ContentFilterConfig{})
+	for relay, want := range map[string]bool{
+		"127.0.0.1[127.0.0.1]:10024":      true,
+		"localhost[::1]:10026":            true,
+		"localhost[127.0.0.1]:10025":      false,
+		"mx.example.org[198.51.100.1]:25": false,
+		"none":                            false,
+	} {
+		if got := f.isFilterRelay(relay); got != want {
+			t.Errorf("isFilterRelay(%q) = %v", relay, got)
+		}
+	}
+
+	f = newContentFilter(ContentFilterConfig{
+		Relays: []string{"scanner[192.0.2.10]:10024"},
+		Smtpd:  []string{"postfix-reinject/smtpd"},
+	})
+	if !f.isFilterRelay("scanner[192.0.2.10]:10024") || f.isFilterRelay("127.0.0.1[127.0.0.1]:10024") {
+		t.Error("configured relays are not used")
+	}
+	for _, c := range []struct {
+		prefix, s string
+		want      bool
+	}{
+		{"Apr  1 10:00:00 mail postfix-reinject/", "smtpd[1]: 1B2D132D5F: client=localhost[127.0.0.1]", true},
+		{"Apr  1 10:00:00 mail postfix/", "smtpd[1]: 1B2D132D5F: client=localhost[127.0.0.1]", false},
+		{"Apr  1 10:00:00 mail postfix/", "smtpd[1]: 1B2D132D5F: client=localhost[127.0.0.1], orig_client=x[192.0.2.1]", true},
+	} {
+		if got := f.reinjected(c.prefix, c.s); got != c.want {
+			t.Errorf("reinjected(%q, %q) = %v", c.prefix, c.s, got)
+		}
+	}
+
+	f = newContentFilter(ContentFilterConfig{Loopback: true})
+	for s, want := range map[string]bool{
+		"smtpd[1]: 1B2D132D5F: client=localhost[127.0.0.1]":        true,
+		"smtpd[1]: 1B2D132D5F: client=localhost[::1]":              true,
+		"smtpd[1]: 1B2D132D5F: client=mail.example.net[192.0.2.1]": false,
+		"smtpd[1]: 1B2D132D5F: client=unknown[127.0.0.1x]":         false,
+	} {
+		if got := f.reinjected("Apr  1 10:00:00 mail postfix/", s); got != want {
+			t.Errorf("loopback reinjected(%q) = %v", s, got)
+		}
+	}
+}
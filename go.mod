@@ -3,8 +3,14 @@ module github.com/aadz/mlogtail
 go 1.25.2
 
 require (
-	github.com/hpcloud/tail v1.0.0 // indirect
-	golang.org/x/sys v0.37.0 // indirect
+	github.com/hpcloud/tail v1.0.0
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.17
+	golang.org/x/sys v0.37.0
+)
+
+require (
+	github.com/fsnotify/fsnotify v1.10.1 // indirect
 	gopkg.in/fsnotify.v1 v1.4.7 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
 )
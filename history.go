@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	historyStep           = time.Minute      // resolution of the ring buffer
+	historySampleInterval = 10 * time.Second // how often counters are sampled
+)
+
+// historyBucket holds counter increments for one minute
+type historyBucket struct {
+	minute int64 // Unix time of the minute start
+	deltas map[string]uint64
+}
+
+// counterHistory is a ring buffer of per-minute counter deltas. It is
+// fed from the never reset counter totals, so `reset` does not affect it.
+type counterHistory struct {
+	sync.Mutex
+	buckets    []historyBucket
+	head       int // index of the most recent bucket
+	lastTotals map[string]uint64
+}
+
+// HistoryPoint is one step of a /history response
+type HistoryPoint struct {
+	Time  time.Time `json:"time"`
+	Value uint64    `json:"value"`
+}
+
+// HistoryResponse структура для JSON-ответа /history
+type HistoryResponse struct {
+	Counter string         `json:"counter"`
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Step    string         `json:"step"`
+	Points  []HistoryPoint `json:"points"`
+}
+
+var history *counterHistory // nil if history is disabled or in file mode
+
+func newCounterHistory(retention time.Duration) *counterHistory {
+	n := int(retention / historyStep)
+	if n < 1 {
+		n = 1
+	}
+	return &counterHistory{
+		buckets: make([]historyBucket, n),
+		head:    n - 1,
+	}
+}
+
+func (h *counterHistory) run() {
+	ticker := time.NewTicker(historySampleInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		h.sample(now)
+	}
+}
+
+// sample adds the counters' growth since the previous call to the
+// bucket of the current minute
+func (h *counterHistory) sample(now time.Time) {
+	totals := msgStatusCounters.snapshotTotals()
+
+	h.Lock()
+	defer h.Unlock()
+	minute := now.Truncate(historyStep).Unix()
+	b := &h.buckets[h.head]
+	if b.minute != minute {
+		h.head = (h.head + 1) % len(h.buckets)
+		b = &h.buckets[h.head]
+		b.minute = minute
+		b.deltas = make(map[string]uint64)
+	}
+	if h.lastTotals != nil {
+		for k, v := range totals {
+			if d := v - h.lastTotals[k]; d > 0 {
+				b.deltas[k] += d
+			}
+		}
+	}
+	h.lastTotals = totals
+}
+
+// sum returns the total increment of a counter in [from, to)
+func (h *counterHistory) sum(counter string, from, to time.Time) uint64 {
+	h.Lock()
+	defer h.Unlock()
+	var res uint64
+	fromMin, toMin := from.Truncate(historyStep).Unix(), to.Unix()
+	for _, b := range h.buckets {
+		if b.deltas != nil && b.minute >= fromMin && b.minute < toMin {
+			res += b.deltas[counter]
+		}
+	}
+	return res
+}
+
+// series returns the counter increments in [from, to) grouped by step
+func (h *counterHistory) series(counter string, from, to time.Time, step time.Duration) []HistoryPoint {
+	from = from.Truncate(step)
+	var res []HistoryPoint
+	for t := from; t.Before(to); t = t.Add(step) {
+		res = append(res, HistoryPoint{Time: t, Value: 0})
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	for _, b := range h.buckets {
+		if b.deltas == nil {
+			continue
+		}
+		bt := time.Unix(b.minute, 0)
+		if bt.Before(from) || !bt.Before(to) {
+			continue
+		}
+		res[int(bt.Sub(from)/step)].Value += b.deltas[counter]
+	}
+	return res
+}
+
+// windowSum is used by socket commands like "bounced 15m"
+func (h *counterHistory) windowSum(counter string, window time.Duration) uint64 {
+	now := time.Now()
+	h.sample(now)
+	return h.sum(counter, now.Add(-window), now.Add(historyStep))
+}
+
+// parseHistoryTime accepts RFC3339 time, local "2006-01-02 15:04:05"
+// or "2006-01-02" time, Unix seconds or a duration which is counted
+// back from now ("1h" and "-1h" both mean an hour ago)
+func parseHistoryTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(s, "-")); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("Incorrect time %q", s)
+}
+
+// handleHistory обрабатывает запрос /history
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	badRequest := func(msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: msg})
+	}
+
+	if history == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "History is disabled"})
+		return
+	}
+
+	q := r.URL.Query()
+	counter := q.Get("counter")
+	if !strArrayLookup(PostfixStatusNames[:], counter) {
+		badRequest(fmt.Sprintf("Unknown counter: %s", counter))
+		return
+	}
+
+	now := time.Now()
+	history.sample(now)
+	from, to := now.Add(-time.Hour), now
+	step := historyStep
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = parseHistoryTime(v, now); err != nil {
+			badRequest(err.Error())
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = parseHistoryTime(v, now); err != nil {
+			badRequest(err.Error())
+			return
+		}
+	}
+	if v := q.Get("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil || step < historyStep || step%historyStep != 0 {
+			badRequest(fmt.Sprintf("Step should be a multiple of %s", historyStep))
+			return
+		}
+	}
+	if !from.Before(to) {
+		badRequest("Parameter from should be before to")
+		return
+	}
+	if to.Sub(from)/step > 10000 {
+		badRequest("Too many points requested")
+		return
+	}
+
+	json.NewEncoder(w).Encode(HistoryResponse{
+		Counter: counter,
+		From:    from,
+		To:      to,
+		Step:    step.String(),
+		Points:  history.series(counter, from, to, step),
+	})
+}
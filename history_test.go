@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCounterHistory(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	h := newCounterHistory(time.Hour)
+	start := time.Date(2025, 4, 1, 10, 0, 0, 0, time.UTC)
+	h.sample(start)
+
+	for i := 0; i < 3; i++ {
+		msgStatusCounters.inc("bounced", 1)
+	}
+	h.sample(start.Add(30 * time.Second))
+
+	msgStatusCounters.reset() // reset should not affect history
+	msgStatusCounters.inc("bounced", 2)
+	h.sample(start.Add(5 * time.Minute))
+
+	if sum := h.sum("bounced", start, start.Add(10*time.Minute)); sum != 5 {
+		t.Errorf("Expected bounced sum 5, got %d", sum)
+	}
+	if sum := h.sum("bounced", start.Add(time.Minute), start.Add(10*time.Minute)); sum != 2 {
+		t.Errorf("Expected bounced sum 2, got %d", sum)
+	}
+
+	points := h.series("bounced", start, start.Add(10*time.Minute), 5*time.Minute)
+	if len(points) != 2 || points[0].Value != 3 || points[1].Value != 2 {
+		t.Errorf("unexpected series %v", points)
+	}
+}
+
+func TestCounterHistoryRingOverflow(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	h := newCounterHistory(3 * time.Minute)
+	start := time.Date(2025, 4, 1, 10, 0, 0, 0, time.UTC)
+	h.sample(start)
+	for i := 1; i <= 5; i++ {
+		msgStatusCounters.inc("delivered", 1)
+		h.sample(start.Add(time.Duration(i) * time.Minute))
+	}
+	// only the last three minutes are kept
+	if sum := h.sum("delivered", start, start.Add(time.Hour)); sum != 3 {
+		t.Errorf("Expected delivered sum 3, got %d", sum)
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2025, 4, 1, 10, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"2025-04-01T08:00:00Z": now.Add(-2 * time.Hour),
+		"1743494400":           time.Unix(1743494400, 0),
+		"15m":                  now.Add(-15 * time.Minute),
+		"-1h":                  now.Add(-time.Hour),
+		"2025-04-01 09:30":     now.Add(-30 * time.Minute),
+		"2025-03-31":           now.Add(-34 * time.Hour),
+	}
+	for s, want := range tests {
+		got, err := parseHistoryTime(s, now)
+		if err != nil {
+			t.Errorf("parseHistoryTime(%q) error: %v", s, err)
+		} else if !got.Equal(want) {
+			t.Errorf("parseHistoryTime(%q) = %v, want %v", s, got, want)
+		}
+	}
+	if _, err := parseHistoryTime("yesterday", now); err == nil {
+		t.Error("Expected error for incorrect time")
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	history = newCounterHistory(time.Hour)
+	defer func() { history = nil }()
+	history.sample(time.Now())
+	msgStatusCounters.inc("bounced", 7)
+
+	req := httptest.NewRequest("GET", "/history?counter=bounced&from=30m&step=5m", nil)
+	rr := httptest.NewRecorder()
+	handleHistory(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var response HistoryResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	var sum uint64
+	for _, p := range response.Points {
+		sum += p.Value
+	}
+	if sum != 7 {
+		t.Errorf("Expected bounced sum 7, got %d", sum)
+	}
+
+	req = httptest.NewRequest("GET", "/history?counter=bounced&step=90s", nil)
+	rr = httptest.NewRecorder()
+	handleHistory(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for incorrect step, got %v", rr.Code)
+	}
+}
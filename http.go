@@ -22,6 +22,9 @@ type StatsResponse struct {
 	Held           uint64 `json:"held"`
 	Discarded      uint64 `json:"discarded"`
 	QueueSize      int    `json:"queue_size"`
+
+	Groups map[string]GroupStats `json:"groups,omitempty"`
+	Rates  map[string]RateValues `json:"rates,omitempty"`
 }
 
 // CounterResponse структура для JSON-ответа одного счетчика
@@ -51,6 +54,13 @@ func getPostfixQueueSize() int {
 
 // getStatsJSON возвращает все статистики в виде JSON
 func getStatsJSON() StatsResponse {
+	stats := getCountersJSON()
+	stats.QueueSize = getPostfixQueueSize()
+	return stats
+}
+
+// getCountersJSON возвращает значения счетчиков без размера очереди
+func getCountersJSON() StatsResponse {
 	msgStatusCounters.lock()
 	defer msgStatusCounters.unlock()
 
@@ -65,7 +75,7 @@ func getStatsJSON() StatsResponse {
 		Rejected:       msgStatusCounters.counters["rejected"],
 		Held:           msgStatusCounters.counters["held"],
 		Discarded:      msgStatusCounters.counters["discarded"],
-		QueueSize:      getPostfixQueueSize(),
+		Groups:         msgStatusCounters.groupsJSON(),
 	}
 }
 
@@ -76,7 +86,7 @@ func getCounterJSON(counter string) CounterResponse {
 
 	return CounterResponse{
 		Counter: counter,
-		Value:   msgStatusCounters.counters[counter],
+		Value:   msgStatusCounters.value(counter),
 	}
 }
 
@@ -92,6 +102,9 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	stats := getStatsJSON()
+	if r.URL.Query().Get("rates") == "1" && rates != nil {
+		stats.Rates = rates.get()
+	}
 	json.NewEncoder(w).Encode(stats)
 }
 
@@ -104,15 +117,7 @@ func handleCounter(w http.ResponseWriter, r *http.Request) {
 	counter := strings.TrimSpace(path)
 
 	// Проверяем, существует ли такой счетчик
-	validCounter := false
-	for _, name := range PostfixStatusNames {
-		if counter == name {
-			validCounter = true
-			break
-		}
-	}
-
-	if !validCounter {
+	if !counterNameValid(counter) {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(ErrorResponse{
 			Error: fmt.Sprintf("Unknown counter: %s", counter),
@@ -176,6 +181,18 @@ func startHTTPServer(addr string) {
 	http.HandleFunc("/reset", handleReset)
 	http.HandleFunc("/stats_reset", handleStatsReset)
 	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/events", handleEvents)
+	http.HandleFunc("/history", handleHistory)
+	http.HandleFunc("/alerts", handleAlerts)
+	http.HandleFunc("/message/", handleMessage)
+	http.HandleFunc("/messages", handleMessages)
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/auth/suspicious", handleAuthSuspicious)
+	http.HandleFunc("/auth/top", handleAuthTop)
+	http.HandleFunc("/top/clients", handleTop)
+	http.HandleFunc("/top/networks", handleTop)
+	http.HandleFunc("/top/senders", handleTopSenders)
+	http.HandleFunc("/relays", handleRelays)
 
 	fmt.Printf("Starting HTTP server on %s\n", addr)
 	if err := http.ListenAndServe(addr, nil); err != nil {
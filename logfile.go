@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const logReadBufferSize = 64 * 1024
+
+// compressed file formats by their magic bytes
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	magicXz    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// logFile is an opened log file, decompressed if required
+type logFile struct {
+	io.Reader
+	closers []func() error
+}
+
+func (f *logFile) Close() error {
+	var res error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		if err := f.closers[i](); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// openLogFile opens a log file or STDIN if the path is "-". Gzip, bzip2,
+// xz and zstd files are recognized by their first bytes and decompressed.
+func openLogFile(path string) (*logFile, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	res := &logFile{closers: []func() error{f.Close}}
+
+	buf := bufio.NewReaderSize(f, logReadBufferSize)
+	magic, _ := buf.Peek(len(magicXz))
+	var err error
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(buf); err == nil {
+			res.Reader = r
+			res.closers = append(res.closers, r.Close)
+		}
+	case bytes.HasPrefix(magic, magicBzip2):
+		res.Reader = bzip2.NewReader(buf)
+	case bytes.HasPrefix(magic, magicXz):
+		res.Reader, err = xz.NewReader(buf)
+	case bytes.HasPrefix(magic, magicZstd):
+		var r *zstd.Decoder
+		if r, err = zstd.NewReader(buf); err == nil {
+			res.Reader = r
+			res.closers = append(res.closers, func() error { r.Close(); return nil })
+		}
+	default:
+		res.Reader = buf
+	}
+	if err != nil {
+		res.Close()
+		return nil, fmt.Errorf("Cannot decompress %s: %v", path, err)
+	}
+	return res, nil
+}
+
+// readLogLines classifies every line of r within the time filter and
+// calls apply for it. With more than one worker the lines are read and
+// classified in parallel. It returns stop = true if the lines are past
+// the filter end.
+func readLogLines(r io.Reader, filter *timeFilter, workers int, apply func(string, postfixLine)) (stop bool, err error) {
+	if workers > 1 {
+		return readLogLinesParallel(r, filter, workers, parallelChunkSize, apply)
+	}
+	buf := bufio.NewReaderSize(r, logReadBufferSize)
+	for {
+		line, err := buf.ReadString('\n')
+		if len(line) > 0 {
+			if ok, stop := filter.check(line); stop {
+				return true, nil
+			} else if ok {
+				apply(line, classifyPostfixLine(line))
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+// firstLineTime returns the time of the first line of a log file
+func firstLineTime(path string, now time.Time) (time.Time, bool) {
+	f, err := openLogFile(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	line, _ := bufio.NewReaderSize(f, logReadBufferSize).ReadString('\n')
+	return logLineTime(line, now)
+}
+
+// sortLogFiles sorts log files in chronological order by their first
+// line times, or by modification times if there are no timestamps
+func sortLogFiles(paths []string, now time.Time) ([]string, error) {
+	if len(paths) < 2 {
+		return paths, nil
+	}
+	times := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if path == "-" {
+			return nil, fmt.Errorf("STDIN cannot be read along with other files")
+		}
+		st, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if ts, ok := firstLineTime(path, now); ok {
+			times[path] = ts
+		} else {
+			times[path] = st.ModTime()
+		}
+	}
+	res := append([]string{}, paths...)
+	sort.SliceStable(res, func(i, j int) bool { return times[res[i]].Before(times[res[j]]) })
+	return res, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const logFileTestLine = "Apr  1 10:00:00 mail postfix/smtpd[1]: AD1: client=x[1.2.3.4]\n"
+
+// logFileTestBzip2 is logFileTestLine compressed by bzip2, there is no
+// bzip2 writer in the standard library
+const logFileTestBzip2 = "QlpoOTFBWSZTWb/NlHYAAA/fgAAQQAH8EiQAAAovJ9xAIABUYwAAAA0Ippp6epNqZGQG1HqUV4Qo9PkjHAnGKurjPDQ4EwpZObRuPllv2uVw3uaiwJsAS9geF3JFOFCQv82Udg=="
+
+func compressTestLog(t *testing.T, format, s string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch format {
+	case "plain":
+		return []byte(s)
+	case "bzip2":
+		data, _ := base64.StdEncoding.DecodeString(logFileTestBzip2)
+		return data
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "xz":
+		w, err = xz.NewWriter(&buf)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, s)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestOpenLogFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, format := range []string{"plain", "gzip", "bzip2", "xz", "zstd"} {
+		path := filepath.Join(dir, "mail.log."+format)
+		if err := os.WriteFile(path, compressTestLog(t, format, logFileTestLine), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := openLogFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil || string(data) != logFileTestLine {
+			t.Errorf("%s: unexpected content %q, error %v", format, data, err)
+		}
+	}
+
+	path := filepath.Join(dir, "broken.gz")
+	os.WriteFile(path, []byte{0x1f, 0x8b, 0}, 0644)
+	if _, err := openLogFile(path); err == nil {
+		t.Error("Expected an error for a broken gzip file")
+	}
+}
+
+func TestSortLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"mail.log":      "Apr  3 00:00:01 mail postfix/smtpd[1]: connect from x[1.2.3.4]\n",
+		"mail.log.1":    "Apr  2 00:00:01 mail postfix/smtpd[1]: connect from x[1.2.3.4]\n",
+		"mail.log.2.gz": "Apr  1 00:00:01 mail postfix/smtpd[1]: connect from x[1.2.3.4]\n",
+	}
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		format := "plain"
+		if filepath.Ext(name) == ".gz" {
+			format = "gzip"
+		}
+		os.WriteFile(path, compressTestLog(t, format, content), 0644)
+		paths = append(paths, path)
+	}
+	res, err := sortLogFiles(paths, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"mail.log.2.gz", "mail.log.1", "mail.log"} {
+		if filepath.Base(res[i]) != name {
+			t.Errorf("unexpected order %v", res)
+			break
+		}
+	}
+	if _, err := sortLogFiles([]string{"-", paths[0]}, time.Now()); err == nil {
+		t.Error("Expected an error for STDIN with other files")
+	}
+}
+
+func TestReadLogLines(t *testing.T) {
+	var lines []string
+	log := "Apr  1 10:00:00 a\nApr  1 11:00:00 b\nApr  1 12:00:00 c" // no newline at the end
+	stop, err := readLogLines(bytes.NewBufferString(log), nil, 1, func(s string, _ postfixLine) { lines = append(lines, s) })
+	if stop || err != nil || len(lines) != 3 {
+		t.Errorf("unexpected result %v, %v, %q", stop, err, lines)
+	}
+
+	lines = nil
+	now := time.Now()
+	first, _ := logLineTime(log, now)
+	filter := &timeFilter{until: first.Add(30 * time.Minute), now: now}
+	stop, _ = readLogLines(bytes.NewBufferString(log), filter, 1, func(s string, _ postfixLine) { lines = append(lines, s) })
+	if !stop || len(lines) != 1 {
+		t.Errorf("unexpected filtered result %v, %q", stop, lines)
+	}
+}
+
+func TestIsCommandName(t *testing.T) {
+	for arg, want := range map[string]bool{
+		"report": true, "stats": true, "bounced": true, "tls-inbound": true,
+		"re": false, "mail.log.1": false, "dovecot-imap-login": false,
+	} {
+		if got := isCommandName(arg, "postfix"); got != want {
+			t.Errorf("isCommandName(%q) = %v, expected %v", arg, got, want)
+		}
+	}
+	if !isCommandName("dovecot-imap-login", "postfix, dovecot") {
+		t.Error("dovecot counter is not a command name with -t postfix,dovecot")
+	}
+	if len(enabledModules) != 0 || counterNameValid("dovecot-imap-login") {
+		t.Error("log types are enabled by the lookup")
+	}
+}
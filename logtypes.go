@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// logModule parses lines of a program other than Postfix writing to
+// the same mail log, it is enabled by the -t option
+type logModule struct {
+	name    string
+	group   *counterGroup
+	parse   func(prefix, tag, msg string) // prefix is the syslog part before the tag
+	postfix func(s string)                // Postfix lines about the program, s is the rest after "postfix/"
+}
+
+// logModules lists the modules known to -t
+var logModules = []*logModule{
+	&antispamModule,
+	&dovecotModule,
+}
+
+var (
+	postfixEnabled = true
+	enabledModules []*logModule
+)
+
+// logTypeNames returns the log types allowed in -t
+func logTypeNames() []string {
+	res := []string{"postfix"}
+	for _, m := range logModules {
+		res = append(res, m.name)
+	}
+	return res
+}
+
+// setLogTypes enables parsers by a comma separated list of log types,
+// e.g. "postfix,antispam", and makes their counters known
+func setLogTypes(types string) error {
+	enabled := make(map[string]bool)
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.TrimSpace(t); !strArrayLookup(logTypeNames(), t) {
+			return fmt.Errorf("Mail log type can be one or more of %s separated by commas", strings.Join(logTypeNames(), ", "))
+		}
+		enabled[t] = true
+	}
+
+	postfixEnabled = enabled["postfix"]
+	enabledModules = nil
+	isModuleGroup := make(map[*counterGroup]bool)
+	for _, m := range logModules {
+		isModuleGroup[m.group] = true
+		if enabled[m.name] {
+			enabledModules = append(enabledModules, m)
+		}
+	}
+	groups := counterGroups[:0:0]
+	for _, g := range counterGroups {
+		if !isModuleGroup[g] && g != &customCounters {
+			groups = append(groups, g)
+		}
+	}
+	for _, m := range enabledModules {
+		groups = append(groups, m.group)
+	}
+	if len(customCounterRules) > 0 { // custom counters are the last ones
+		groups = append(groups, &customCounters)
+	}
+	counterGroups = groups
+	return nil
+}
+
+// moduleLineParse passes a Postfix line to the enabled modules parsing
+// them, s is the rest after "postfix/"
+func moduleLineParse(s string) {
+	for _, m := range enabledModules {
+		if m.postfix != nil {
+			m.postfix(s)
+		}
+	}
+}
+
+// otherLineParse passes a line which is not a Postfix one to the
+// enabled modules
+func otherLineParse(s string) {
+	if len(enabledModules) == 0 {
+		return
+	}
+	prefix, tag, msg, ok := syslogLine(s)
+	if !ok {
+		return
+	}
+	for _, m := range enabledModules {
+		m.parse(prefix, tag, msg)
+	}
+}
+
+// syslogLine splits "Apr  1 10:00:00 host amavis[123]: message" into the
+// prefix "Apr  1 10:00:00 host ", the tag "amavis" and the message
+func syslogLine(s string) (prefix, tag, msg string, ok bool) {
+	i := classicTimeLen(s)
+	if i == 0 {
+		i = isoTimeLen(s)
+	}
+	if i == 0 || i >= len(s) || s[i] != ' ' {
+		return "", "", "", false
+	}
+	j := strings.IndexByte(s[i+1:], ' ')
+	if j <= 0 {
+		return "", "", "", false
+	}
+	prefix, s = s[:i+j+2], s[i+j+2:]
+	k := strings.IndexAny(s, "[: ")
+	if k <= 0 {
+		return "", "", "", false
+	}
+	tag, s = s[:k], s[k:]
+	if s[0] == '[' {
+		if k = strings.IndexByte(s, ']'); k < 0 {
+			return "", "", "", false
+		}
+		s = s[k+1:]
+	}
+	if !strings.HasPrefix(s, ": ") {
+		return "", "", "", false
+	}
+	return prefix, tag, strings.TrimRight(s[2:], "\r\n"), true
+}
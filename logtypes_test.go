@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSyslogLine(t *testing.T) {
+	for _, c := range []struct{ line, prefix, tag, msg string }{
+		{"Apr  1 10:00:00 mail amavis[1234]: (01234-05) Passed CLEAN\n", "Apr  1 10:00:00 mail ", "amavis", "(01234-05) Passed CLEAN"},
+		{"2025-04-01T10:00:00.123+03:00 mail dovecot: imap-login: Login", "2025-04-01T10:00:00.123+03:00 mail ", "dovecot", "imap-login: Login"},
+		{"Apr  1 10:00:00 mail postfix/smtpd[1]: connect from x[192.0.2.1]", "Apr  1 10:00:00 mail ", "postfix/smtpd", "connect from x[192.0.2.1]"},
+		{"Apr  1 10:00:00 mail kernel something", "", "", ""},
+		{"Apr  1 10:00:00 mail amavis[1234 x", "", "", ""},
+		{"not a log line", "", "", ""},
+	} {
+		prefix, tag, msg, ok := syslogLine(c.line)
+		if ok != (c.tag != "") || prefix != c.prefix || tag != c.tag || msg != c.msg {
+			t.Errorf("syslogLine(%q) = %q, %q, %q, %v", c.line, prefix, tag, msg, ok)
+		}
+	}
+}
+
+func TestSetLogTypes(t *testing.T) {
+	defer setLogTypes("postfix")
+	if err := setLogTypes("postfix,nosuch"); err == nil {
+		t.Error("no error for an unknown log type")
+	}
+	if err := setLogTypes("antispam"); err != nil {
+		t.Fatal(err)
+	}
+	if postfixEnabled || len(enabledModules) != 1 || !counterNameValid("antispam-spam") {
+		t.Error("antispam is not enabled alone")
+	}
+	PostfixParserInit(&Config{cmd: "file"})
+	PostfixLineParse("Apr  1 10:00:00 mail postfix/smtpd[1]: connect from x[192.0.2.1]")
+	if v := msgStatusCounters.counters["smtpd-connect"]; v != 0 {
+		t.Errorf("postfix line is parsed with postfix disabled")
+	}
+
+	if err := setLogTypes("postfix"); err != nil {
+		t.Fatal(err)
+	}
+	if !postfixEnabled || len(enabledModules) != 0 || counterNameValid("antispam-spam") {
+		t.Error("antispam is not disabled")
+	}
+}
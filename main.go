@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	//"runtime/pprof"
 
@@ -23,24 +24,42 @@ import (
 
 // type Config map[string]string
 type Config struct {
-	cmd           string
-	cpuprofile    string
-	subCmd        string
-	setFlags      string
-	listen        string
-	lnNetworkType string
-	lnAddress     string
-	maillog       string
-	maillogType   string
-	socketOwner   string
-	socketMode    int
-	httpListen    string
-	httpEnabled   bool
-	initFromFile  bool
+	cmd              string
+	cpuprofile       string
+	subCmd           string
+	setFlags         string
+	listen           string
+	lnNetworkType    string
+	lnAddress        string
+	maillog          string
+	maillogs         []string // file mode can read several files
+	maillogType      string
+	socketOwner      string
+	socketMode       int
+	httpListen       string
+	httpEnabled      bool
+	initFromFile     bool
+	eventsInterval   time.Duration
+	historyRetention time.Duration
+	configFile       string
+	alertRules       []*AlertRule
+	trackMax         int
+	trackAge         time.Duration
+	authWindow       time.Duration
+	authThreshold    int
+	topSize          int
+	senders          SendersConfig
+	relays           RelaysConfig
+	contentFilter    ContentFilterConfig
+	reportFormat     string
+	filter           *timeFilter // -since and -until in file mode
+	bucket           time.Duration
+	bucketFormat     string
+	workers          int
 }
 
 const (
-	cmdAllowed = "stats|stats_reset|reset|tail"
+	cmdAllowed = "stats|stats_reset|reset|rates|trace|messages|top|report|tail"
 )
 
 func main() {
@@ -62,6 +81,7 @@ func main() {
 		switch cfg.cmd {
 		case "tail": // start to tail of the log
 			tailLog(cfg)
+		case "report": // the log file is read below
 		default:
 			if !strings.Contains(cfg.setFlags, "f") {
 				getCurrentStats(cfg)
@@ -69,38 +89,54 @@ func main() {
 		}
 	}
 
-	if strings.Contains(cfg.setFlags, "f") {
-		var err error
-		var logFile *os.File
-		if cfg.maillog == "-" {
-			logFile = os.Stdin
-		} else {
-			logFile, err = os.Open(cfg.maillog)
-			if err != nil {
-				fmt.Printf("Canot open logfile: %s\n", err)
-				os.Exit(1)
-			}
+	if strings.Contains(cfg.setFlags, "f") || cfg.cmd == "report" {
+		files, err := sortLogFiles(cfg.maillogs, time.Now())
+		if err != nil {
+			fmt.Printf("Cannot read log files: %s\n", err)
+			os.Exit(1)
 		}
 
+		if cfg.cmd == "report" {
+			report = newReportCollector()
+		}
 		cfg.cmd = "file" // we are working with a disk saved file of STDIN
 		PostfixParserInit(cfg)
-		buf := bufio.NewReaderSize(logFile, 64*1024)
-		var line string
-		for {
-			line, err = buf.ReadString('\n')
+		var buckets *bucketWriter
+		if cfg.bucket > 0 {
+			buckets = newBucketWriter(os.Stdout, cfg)
+		}
+		for _, path := range files {
+			logFile, err := openLogFile(path)
+			if err != nil {
+				fmt.Printf("Canot open logfile: %s\n", err)
+				os.Exit(1)
+			}
+			stop, err := readLogLines(logFile, cfg.filter, cfg.workers, func(line string, l postfixLine) {
+				buckets.line(line)
+				applyPostfixLine(line, l)
+			})
+			logFile.Close()
 			if err != nil {
+				fmt.Printf("Error reading %s: %s\n", path, err)
+				os.Exit(1)
+			}
+			if stop { // the next files are even later
 				break
-			} else {
-				PostfixLineParse(line)
 			}
 		}
-		if err != io.EOF {
-			fmt.Println(err)
-			os.Exit(1)
+
+		if report != nil {
+			res, err := report.build().render(cfg.reportFormat)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Print(res)
+		} else if buckets != nil {
+			buckets.finish()
 		} else {
 			fmt.Print(PostfixStats())
 		}
-		logFile.Close()
 	}
 }
 
@@ -157,11 +193,9 @@ func getCurrentStats(cfg *Config) {
 	} else {
 		cmd = cfg.cmd
 	}
-	//buf := make([]byte, 384)
-	buf := make([]byte, 2048)
 	conn.Write([]byte(cmd))
-	cnt, _ := conn.Read(buf)
-	fmt.Printf("%s", string(buf[:cnt]))
+	resp, _ := io.ReadAll(conn) // the reader process closes the connection after the response
+	fmt.Printf("%s", string(resp))
 	conn.Close()
 }
 
@@ -176,22 +210,63 @@ func handleSIGINTKILL(ln net.Listener, cfg *Config) {
 }
 
 func readCmdLine(cfg *Config) {
-	var cpuprofile, listen, maillog, maillogType, socketOwner, httpListen string
-	var socketMode int
+	var cpuprofile, listen, maillog, maillogType, socketOwner, httpListen, configFile, since, until string
+	var socketMode, trackMax, authThreshold, topSize, workers int
 	var initFromFile bool
+	var eventsInterval, historyRetention, trackAge, authWindow, bucket time.Duration
+	var bucketFormat string
 
 	//flag.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile to file")
+	flag.IntVar(&authThreshold, "auth-threshold", 10, "SASL authentication failures within -auth-window making a client IP suspicious")
+	flag.DurationVar(&authWindow, "auth-window", 10*time.Minute, "Sliding window for SASL authentication failures and logins tracking")
+	flag.DurationVar(&bucket, "bucket", 0, "Split file mode counters into intervals of this duration by the log line time, e.g. 1h")
+	flag.StringVar(&bucketFormat, "bucket-format", "table", "Output format of -bucket intervals: table, csv or json (JSON lines)")
+	flag.StringVar(&configFile, "c", "", "Configuration file (JSON) with alert rules, sender limits and custom counters")
 	flag.StringVar(&maillog, "f", "/var/log/mail.log", "Mail log file path, if the path is \"-\" then read from STDIN")
 	flag.Bool("h", false, "Show this help")
+	flag.DurationVar(&eventsInterval, "events-interval", time.Second, "Minimum interval between counter updates sent to /events clients")
+	flag.DurationVar(&historyRetention, "history", 48*time.Hour, "Keep per-minute counter history for this period, 0 disables it")
 	flag.StringVar(&httpListen, "http", "", "HTTP server address (e.g., :8080 or 0.0.0.0:8080) to serve stats as JSON")
 	flag.BoolVar(&initFromFile, "init-from-file", false, "Read entire log file on startup to initialize counters, then continue tailing")
 	flag.StringVar(&listen, "l", "unix:/var/run/mlogtail.sock", "Log reader process is listening for commands on a socket file, or IPv4:PORT,\nor [IPv6]:PORT")
 	flag.StringVar(&socketOwner, "o", "", "Set a socket OWNER[:GROUP] while listening on a socket file")
+	flag.StringVar(&since, "since", "", "Read only lines logged since this time in file mode: RFC3339 or \"2006-01-02 15:04\" time,\nor a duration back from now, e.g. 3h")
 	flag.IntVar(&socketMode, "p", 666, "Set a socket access permissions while listening on a socket file")
-	flag.StringVar(&maillogType, "t", "postfix", "Mail log type. It is \"postfix\" only allowed for now")
+	flag.IntVar(&topSize, "top-size", 1000, "Number of client IPs and networks kept in top tables, 0 disables them")
+	flag.DurationVar(&trackAge, "track-age", 24*time.Hour, "Forget tracked messages older than this")
+	flag.IntVar(&trackMax, "track-max", 10000, "Maximum number of messages tracked by queue ID, 0 disables tracking")
+	flag.StringVar(&until, "until", "", "Read only lines logged before this time in file mode, the same format as -since")
+	flag.StringVar(&maillogType, "t", "postfix", "Mail log types separated by commas: "+strings.Join(logTypeNames(), ", ")+",\ne.g. \"postfix,antispam\"")
 	flag.Bool("v", false, "Show version information and exit")
+	flag.IntVar(&workers, "workers", 1, "Number of goroutines classifying log lines in file mode, more than 1 enables parallel\nclassification, the counters are still updated by one goroutine")
 	flag.Parse()
 
+	// more log files in file mode, e.g. "-f /var/log/mail.log* -bucket 1h",
+	// the options after them are parsed too. They end with "--" or a
+	// command or counter name, even if there is such a file.
+	cmds := flag.Args()
+	var moreLogs []string
+	fileMode := false
+	flag.Visit(func(f *flag.Flag) { fileMode = fileMode || f.Name == "f" })
+	for fileMode && len(cmds) > 0 {
+		if cmds[0] == "--" {
+			cmds = cmds[1:]
+			break
+		}
+		if isCommandName(cmds[0], maillogType) {
+			break
+		}
+		if st, err := os.Stat(cmds[0]); err == nil && st.Mode().IsRegular() {
+			moreLogs = append(moreLogs, cmds[0])
+			cmds = cmds[1:]
+		} else if len(cmds[0]) > 1 && cmds[0][0] == '-' {
+			flag.CommandLine.Parse(cmds)
+			cmds = flag.Args()
+		} else {
+			break
+		}
+	}
+
 	// create a list of explicitly set flags
 	var showHelp, showVersion bool
 	fsetFunc := func(f *flag.Flag) {
@@ -215,26 +290,130 @@ func readCmdLine(cfg *Config) {
 	cfg.cpuprofile = cpuprofile
 	cfg.listen = listen
 	cfg.maillog = maillog
+	cfg.maillogs = append([]string{maillog}, moreLogs...)
 	cfg.maillogType = maillogType
+	if err := setLogTypes(maillogType); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	cfg.socketOwner = socketOwner
 	cfg.httpListen = httpListen
 	cfg.httpEnabled = len(httpListen) > 0
 	cfg.initFromFile = initFromFile
+	cfg.historyRetention = historyRetention
+	cfg.configFile = configFile
+	cfg.trackMax = trackMax
+	cfg.trackAge = trackAge
+	cfg.authWindow = authWindow
+	cfg.authThreshold = authThreshold
+	cfg.topSize = topSize
+	if bucket < 0 || bucket > 0 && bucket < time.Minute {
+		fmt.Printf("Bucket interval should be at least 1m\n")
+		os.Exit(1)
+	}
+	if !strArrayLookup(bucketFormats, bucketFormat) {
+		fmt.Printf("Bucket format can be one of %s\n", strings.Join(bucketFormats, ", "))
+		os.Exit(1)
+	}
+	cfg.bucket = bucket
+	cfg.workers = max(workers, 1)
+	cfg.bucketFormat = bucketFormat
+	if since != "" || until != "" {
+		cfg.filter = &timeFilter{now: time.Now()}
+		parseTime := func(name, v string) time.Time {
+			if v == "" {
+				return time.Time{}
+			}
+			t, err := parseHistoryTime(v, cfg.filter.now)
+			if err != nil {
+				fmt.Printf("Incorrect -%s value: %s\n", name, err)
+				os.Exit(1)
+			}
+			return t
+		}
+		cfg.filter.since = parseTime("since", since)
+		cfg.filter.until = parseTime("until", until)
+	}
+	if eventsInterval > 0 {
+		cfg.eventsInterval = eventsInterval
+	} else {
+		fmt.Printf("Events interval should be positive, it is set to 1s\n")
+		cfg.eventsInterval = time.Second
+	}
+
+	// the config file is read in every mode as custom counters are
+	// counted in file mode and requested by name from the daemon
+	if len(configFile) > 0 {
+		if err := loadConfigFile(cfg, configFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	// get not options parameter (command)
-	if flag.NArg() > 0 {
-		cmds := flag.Args()
-		if strings.Contains(cmdAllowed, cmds[0]) {
+	if len(cmds) > 0 {
+		if cmds[0] == "trace" {
+			if len(cmds) != 2 {
+				fmt.Printf("Usage: %s [OPTIONS] trace <QUEUE_ID>\n", os.Args[0])
+				os.Exit(1)
+			}
+			cfg.cmd = cmds[0]
+			cfg.subCmd = "trace " + cmds[1]
+		} else if cmds[0] == "top" { // e.g. "top networks rejected 20"
+			if len(cmds) < 2 || len(cmds) > 4 {
+				fmt.Printf("Usage: %s [OPTIONS] top clients|networks [rejected|connections] [N]\n", os.Args[0])
+				os.Exit(1)
+			}
+			cfg.cmd = cmds[0]
+			cfg.subCmd = strings.Join(cmds, " ")
+		} else if cmds[0] == "report" { // e.g. "report --format html"
+			cfg.cmd = cmds[0]
+			cfg.reportFormat = "text"
+			for i := 1; i < len(cmds); i++ {
+				opt, v, ok := strings.Cut(strings.TrimLeft(cmds[i], "-"), "=")
+				if opt == "format" && !ok && i+1 < len(cmds) {
+					i++
+					v, ok = cmds[i], true
+				}
+				if opt != "format" || !ok || !strArrayLookup(reportFormats, v) {
+					fmt.Printf("Usage: %s [OPTIONS] report [--format %s]\n", os.Args[0], strings.Join(reportFormats, "|"))
+					os.Exit(1)
+				}
+				cfg.reportFormat = v
+			}
+		} else if cmds[0] == "messages" { // search parameters, e.g. from=alice@ since=1h
+			for _, a := range cmds[1:] {
+				if kv := strings.SplitN(a, "=", 2); len(kv) != 2 || !strArrayLookup(searchParams, kv[0]) {
+					fmt.Printf("Incorrect search parameter %q, it can be one of %s=VALUE\n",
+						a, strings.Join(searchParams, "|"))
+					os.Exit(1)
+				}
+			}
 			cfg.cmd = cmds[0]
-		} else if maillogType == "postfix" && strArrayLookup(PostfixStatusNames[:], cmds[0]) {
+			cfg.subCmd = strings.Join(cmds, " ")
+		} else if strArrayLookup(strings.Split(cmdAllowed, "|"), cmds[0]) {
+			cfg.cmd = cmds[0]
+		} else if counterNameValid(cmds[0]) {
 			cfg.cmd = "stats"
 			cfg.subCmd = cmds[0]
+			if len(cmds) > 1 { // a window for the counter, e.g. "bounced 15m"
+				if _, err := time.ParseDuration(cmds[1]); err != nil {
+					fmt.Printf("Incorrect time window %q: %s\n", cmds[1], err)
+					os.Exit(1)
+				}
+				cfg.subCmd += " " + cmds[1]
+			}
 		} else {
-			fmt.Printf("Command can be one of \"%s\"\n", cmdAllowed+"|"+strings.Join(PostfixStatusNames[:], "|"))
+			fmt.Printf("Command can be one of \"%s\"\n", cmdAllowed+"|"+strings.Join(allCounterNames(), "|"))
 			os.Exit(1)
 		}
 	}
 
+	if cfg.cmd == "report" && cfg.bucket > 0 {
+		fmt.Printf("-bucket cannot be used with report\n")
+		os.Exit(1)
+	}
+
 	// some configuratioin of tailing process
 	if cfg.cmd == "tail" {
 		if socketMode <= 777 {
@@ -309,7 +488,7 @@ func initCountersFromFile(filename string) error {
 		return fmt.Errorf("Cannot initialize from STDIN")
 	}
 
-	file, err := os.Open(filename)
+	file, err := openLogFile(filename)
 	if err != nil {
 		return fmt.Errorf("Cannot open log file for initialization: %v", err)
 	}
@@ -343,6 +522,8 @@ func tailLog(cfg *Config) {
 
 	// Запускаем HTTP сервер, если указан флаг -http
 	if cfg.httpEnabled {
+		events = newEventBroker(cfg.eventsInterval)
+		go events.run()
 		go startHTTPServer(cfg.httpListen)
 	}
 
@@ -360,6 +541,16 @@ func tailLog(cfg *Config) {
 	}
 
 	PostfixParserInit(cfg)
+	if cfg.trackMax > 0 {
+		tracker = newMessageTracker(cfg.trackMax, cfg.trackAge)
+	}
+	authWatch = newAuthWatcher(cfg.authWindow, cfg.authThreshold)
+	if cfg.topSize > 0 {
+		topClients = newTopTables(cfg.topSize)
+	}
+	senderWatch = newSenderWatcher(cfg.senders)
+	relayWatch = newRelayWatcher(cfg.relays)
+	go relayWatch.run()
 
 	// Инициализация счётчиков из всего файла, если указан флаг
 	if cfg.initFromFile {
@@ -368,17 +559,56 @@ func tailLog(cfg *Config) {
 		}
 	}
 
+	// History and rates start after the initialization to not count
+	// the whole log file as the first minute growth
+	if cfg.historyRetention > 0 {
+		history = newCounterHistory(cfg.historyRetention)
+		history.sample(time.Now())
+		go history.run()
+	}
+	rates = newCounterRates()
+	rates.update(time.Now())
+	go rates.run()
+
+	if len(cfg.alertRules) > 0 {
+		alerts = newAlertEngine(cfg.alertRules)
+		go alerts.run()
+	}
+
 	for line := range t.Lines {
 		PostfixLineParse(line.Text)
 	}
 }
 
+// isCommandName checks if a command line argument is a command or a
+// counter name of the log types, the log types are not enabled yet.
+// Custom counters are not known before the config file is read, "--"
+// should precede them after log files.
+func isCommandName(arg, logTypes string) bool {
+	if strArrayLookup(strings.Split(cmdAllowed, "|"), arg) || counterNameValid(arg) {
+		return true
+	}
+	types := strings.Split(logTypes, ",")
+	for i := range types {
+		types[i] = strings.TrimSpace(types[i])
+	}
+	for _, m := range logModules {
+		if strArrayLookup(types, m.name) && (strArrayLookup(m.group.names, arg) || strArrayLookup(m.group.gauges, arg)) {
+			return true
+		}
+	}
+	return false
+}
+
 func usage() {
 	pname := os.Args[0]
 	fmt.Printf("Usage:\n  %s [OPTIONS] tail\n", pname)
-	fmt.Printf("  %s [OPTIONS] \"stats | stats_reset | reset\"\n", pname)
-	fmt.Printf("  %s [OPTIONS] <COUNTER_NAME>\n", pname)
-	fmt.Printf("  %s -f <LOG_FILE_NAME>\n\nOptions:\n", pname)
+	fmt.Printf("  %s [OPTIONS] \"stats | stats_reset | reset | rates\"\n", pname)
+	fmt.Printf("  %s [OPTIONS] <COUNTER_NAME> [WINDOW]\n", pname)
+	fmt.Printf("  %s [OPTIONS] trace <QUEUE_ID>\n", pname)
+	fmt.Printf("  %s [OPTIONS] top clients|networks [rejected|connections] [N]\n", pname)
+	fmt.Printf("  %s [OPTIONS] messages [from=|to=|client=|status=|since=|limit=|offset=VALUE ...]\n", pname)
+	fmt.Printf("  %s -f <LOG_FILE_NAME> [LOG_FILE_NAME ...] [report [--format text|json|html]]\n\nOptions:\n", pname)
 	flag.PrintDefaults()
 	os.Exit(0)
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const metricsPrefix = "mlogtail_"
+
+var reMetricLabel = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// metricName converts a counter name to a Prometheus metric name,
+// "bytes-received" is "mlogtail_bytes_received_total"
+func metricName(counter string) string {
+	return metricsPrefix + strings.ReplaceAll(counter, "-", "_") + "_total"
+}
+
+// metricsString returns the counters in the Prometheus text format. The
+// never reset counter totals are used, so `reset` does not affect them.
+func metricsString() string {
+	var sb strings.Builder
+	counter := func(name string, v uint64) {
+		m := metricName(name)
+		fmt.Fprintf(&sb, "# TYPE %s counter\n%s %d\n", m, m, v)
+	}
+
+	// histograms have no _sum, observations like spam scores may be negative
+	histogram := func(lc labeledCounter) {
+		m := metricsPrefix + strings.ReplaceAll(lc.name, "-", "_")
+		fmt.Fprintf(&sb, "# TYPE %s histogram\n", m)
+		buckets := histogramBuckets(lc.bounds, msgStatusCounters.labeledTotals[lc.name])
+		for i, b := range lc.bounds {
+			fmt.Fprintf(&sb, "%s_bucket{le=\"%s\"} %d\n", m, strconv.FormatFloat(b, 'f', -1, 64), buckets[i])
+		}
+		fmt.Fprintf(&sb, "%s_bucket{le=\"+Inf\"} %d\n%s_count %d\n", m, buckets[len(lc.bounds)], m, buckets[len(lc.bounds)])
+	}
+
+	msgStatusCounters.lock()
+	defer msgStatusCounters.unlock()
+	for _, name := range PostfixStatusNames {
+		counter(name, msgStatusCounters.totals[name])
+	}
+	for _, g := range counterGroups {
+		for _, name := range g.names {
+			counter(name, msgStatusCounters.totals[name])
+		}
+		for _, name := range g.gauges {
+			m := metricsPrefix + strings.ReplaceAll(name, "-", "_")
+			fmt.Fprintf(&sb, "# TYPE %s gauge\n%s %d\n", m, m, msgStatusCounters.gauges[name])
+		}
+		for _, lc := range g.labeled {
+			if lc.bounds != nil {
+				histogram(lc)
+				continue
+			}
+			m := metricName(lc.name)
+			fmt.Fprintf(&sb, "# TYPE %s counter\n", m)
+			values := msgStatusCounters.labeledTotals[lc.name]
+			for _, label := range sortedKeys(values) {
+				fmt.Fprintf(&sb, "%s{%s} %d\n", m, metricLabels(lc.label, label), values[label])
+			}
+		}
+	}
+	return sb.String()
+}
+
+// metricLabels formats label values as `rank="3"`. A counter with several
+// labels has their names and values joined by labelValueSep, the values
+// over the limit are "other" for every label.
+func metricLabels(names, values string) string {
+	v := strings.SplitN(values, labelValueSep, strings.Count(names, labelValueSep)+1)
+	var res []string
+	for i, name := range strings.Split(names, labelValueSep) {
+		value := labelValueOthers
+		if i < len(v) {
+			value = v[i]
+		}
+		res = append(res, name+"="+strconv.Quote(value))
+	}
+	return strings.Join(res, ",")
+}
+
+// handleMetrics обрабатывает запрос /metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, metricsString())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+const parallelChunkSize = 1 << 20 // bytes read at once and given to a worker
+
+// classifiedLine is a line checked by the time filter and classified by
+// a worker
+type classifiedLine struct {
+	line     string
+	ok, stop bool // timeFilter.check results
+	class    postfixLine
+}
+
+type lineChunk struct {
+	seq   int
+	data  string
+	lines []classifiedLine
+}
+
+// readLogLinesParallel is readLogLines with the line time check and the
+// classification done by workers. Lines are applied in the file order by
+// the calling goroutine, so the results are the same as of the
+// sequential reading.
+func readLogLinesParallel(r io.Reader, filter *timeFilter, workers, chunkSize int,
+	apply func(string, postfixLine)) (stop bool, err error) {
+	jobs := make(chan *lineChunk, workers)
+	results := make(chan *lineChunk, workers)
+	done := make(chan struct{}) // the reading should stop
+
+	var readErr error
+	go func() { // split the input into chunks of whole lines
+		defer close(jobs)
+		var rest []byte
+		for seq := 0; ; {
+			buf := make([]byte, len(rest)+chunkSize)
+			copy(buf, rest)
+			n, err := io.ReadFull(r, buf[len(rest):])
+			buf = buf[:len(rest)+n]
+			last := len(buf)
+			if err == nil { // a line may be continued in the next chunk
+				last = bytes.LastIndexByte(buf, '\n') + 1
+			}
+			rest = buf[last:]
+			if last > 0 {
+				select {
+				case jobs <- &lineChunk{seq: seq, data: string(buf[:last])}:
+				case <-done:
+					return
+				}
+				seq++
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			} else if err != nil {
+				readErr = err
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				c.lines = make([]classifiedLine, 0, strings.Count(c.data, "\n")+1)
+				for data := c.data; len(data) > 0; {
+					i := strings.IndexByte(data, '\n') + 1
+					if i == 0 {
+						i = len(data)
+					}
+					cl := classifiedLine{line: data[:i]}
+					if cl.ok, cl.stop = filter.check(cl.line); cl.ok {
+						cl.class = classifyPostfixLine(cl.line)
+					}
+					c.lines = append(c.lines, cl)
+					data = data[i:]
+				}
+				results <- c
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// apply chunks in order, the ones coming early wait in pending
+	pending := make(map[int]*lineChunk)
+	next := 0
+	for c := range results {
+		if stop {
+			continue // drain the workers
+		}
+		pending[c.seq] = c
+		for c = pending[next]; c != nil; c = pending[next] {
+			delete(pending, next)
+			next++
+			for _, cl := range c.lines {
+				if cl.stop {
+					stop = true
+					close(done)
+					break
+				} else if cl.ok {
+					apply(cl.line, cl.class)
+				}
+			}
+			if stop {
+				break
+			}
+		}
+	}
+	if stop {
+		return true, nil
+	}
+	return false, readErr
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parallelTestLog makes a log of n messages going through smtpd, qmgr
+// and delivery agents with some rejects and deferrals
+func parallelTestLog(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		ts := time.Date(2025, 4, 1, 0, 0, 0, 0, time.Local).Add(time.Duration(i) * time.Second).Format(syslogTimeLayout)
+		qid := fmt.Sprintf("%X", 0xA0000000+i)
+		fmt.Fprintf(&b, "%s mail postfix/smtpd[100]: connect from unknown[192.0.2.%d]\n", ts, i%250)
+		fmt.Fprintf(&b, "%s mail postfix/smtpd[100]: %s: client=unknown[192.0.2.%d]\n", ts, qid, i%250)
+		fmt.Fprintf(&b, "%s mail postfix/qmgr[101]: %s: from=<user%d@example.com>, size=%d, nrcpt=1 (queue active)\n", ts, qid, i%10, 1000+i)
+		switch i % 5 {
+		case 0:
+			fmt.Fprintf(&b, "%s mail postfix/smtp[102]: %s: to=<rcpt%d@example.net>, relay=mx.example.net[1.2.3.4]:25, delay=1, dsn=4.4.1, status=deferred (connect to mx.example.net[1.2.3.4]:25: Connection timed out)\n", ts, qid, i)
+		case 1:
+			fmt.Fprintf(&b, "%s mail postfix/smtpd[103]: NOQUEUE: reject: RCPT from unknown[198.51.100.%d]: 554 5.7.1 <x@example.com>: Relay access denied; from=<a@b.c> to=<x@example.com> proto=ESMTP helo=<spam>\n", ts, i%250)
+		}
+		fmt.Fprintf(&b, "%s mail postfix/smtp[102]: %s: to=<rcpt%d@example.net>, relay=mx.example.net[1.2.3.4]:25, delay=1, dsn=2.0.0, status=sent (250 Ok)\n", ts, qid, i)
+		fmt.Fprintf(&b, "%s mail postfix/qmgr[101]: %s: removed\n", ts, qid)
+		fmt.Fprintf(&b, "%s mail postfix/smtpd[100]: disconnect from unknown[192.0.2.%d] ehlo=1 mail=1 rcpt=1 data=1 quit=1 commands=5\n", ts, i%250)
+	}
+	return b.String()
+}
+
+// parseTestLog reads a log and returns all the counters
+func parseTestLog(t testing.TB, log string, filter *timeFilter, workers, chunkSize int) string {
+	PostfixParserInit(&Config{cmd: "file"})
+	var stop bool
+	var err error
+	if workers > 1 {
+		stop, err = readLogLinesParallel(strings.NewReader(log), filter, workers, chunkSize, applyPostfixLine)
+	} else {
+		stop, err = readLogLines(strings.NewReader(log), filter, 1, applyPostfixLine)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%v\n%s", stop, PostfixStats())
+}
+
+func TestReadLogLinesParallel(t *testing.T) {
+	log := parallelTestLog(500) + "Apr  1 01:00:00 mail postfix/smtpd[100]: connect from unknown[192.0.2.1]" // no newline
+	want := parseTestLog(t, log, nil, 1, 0)
+	if !strings.Contains(want, "\nreceived        500\n") || !strings.Contains(want, "\nrejected        100\n") {
+		t.Fatalf("unexpected sequential counters:\n%s", want)
+	}
+	for _, chunkSize := range []int{1, 100, 4096, parallelChunkSize} {
+		if got := parseTestLog(t, log, nil, 4, chunkSize); got != want {
+			t.Errorf("chunk size %d: parallel counters differ:\n%s\nwant:\n%s", chunkSize, got, want)
+		}
+	}
+
+	now := time.Date(2025, 4, 2, 0, 0, 0, 0, time.Local)
+	filter := &timeFilter{since: now.Add(-23*time.Hour - 55*time.Minute), until: now.Add(-23*time.Hour - 50*time.Minute), now: now}
+	want = parseTestLog(t, log, filter, 1, 0)
+	if !strings.HasPrefix(want, "true\n") || !strings.Contains(want, "\nreceived        200\n") {
+		t.Fatalf("unexpected filtered counters:\n%s", want)
+	}
+	if got := parseTestLog(t, log, filter, 4, 1000); got != want {
+		t.Errorf("parallel filtered counters differ:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func benchmarkReadLogLines(b *testing.B, workers int) {
+	log := parallelTestLog(20000)
+	b.SetBytes(int64(len(log)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseTestLog(b, log, nil, workers, parallelChunkSize)
+	}
+}
+
+func BenchmarkReadLogLinesSequential(b *testing.B) { benchmarkReadLogLines(b, 1) }
+func BenchmarkReadLogLinesParallel(b *testing.B)   { benchmarkReadLogLines(b, 8) }
@@ -7,28 +7,35 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type MsgStatusCountersType struct {
 	sync.Mutex
-	counters    map[string]uint64 // counters of message delivery status
-	bytesDlvMap map[string]uint64 // counters of messages size
-	newRcvMap   map[string]bool   // a map listing new, just appeared messages
+	counters      map[string]uint64            // counters of message delivery status
+	totals        map[string]uint64            // the same counters but never reset
+	labeled       map[string]map[string]uint64 // counters split by a label value
+	labeledTotals map[string]map[string]uint64 // the same but never reset
+	gauges        map[string]uint64            // current values, not reset
+	bytesDlvMap   map[string]uint64            // counters of messages size
+	newRcvMap     map[string]bool              // a map listing new, just appeared messages
+	filterRcvMap  map[string]bool              // new messages coming back from the content filter
 }
 
 const (
 	// We expect that postfix prefix line will be in form:
 	// "Jul 22 19:06:42 hostname postfix(instance_name)?/"
+	// or with RFC3339 time "2025-07-22T19:06:42.123456+03:00 hostname ..."
 	// where "instance_name" usually is not specified in
 	// single instance mode
-	postfixLogLine  = `^[JAMDFONS][aeucop][nrbcglptvy] [1-3 ]\d [0-2]\d:[0-5]\d:[0-5]\d \S+ postfix[^/ ]*/`
+	postfixLogLine  = `^(?:[JAMDFONS][aeucop][nrbcglptvy] [1-3 ]\d [0-2]\d:[0-5]\d:[0-5]\d|\d{4}-[01]\d-[0-3]\dT[0-2]\d:[0-5]\d:[0-5]\d(?:\.\d+)?(?:Z|[+-]\d\d:?\d\d)?) \S+ postfix[^/ ]*/`
 	receivedLine    = `^(?:(?:s(?:mtps/|ubmission)/)?smtp[ds]|pickup)\[\d+\]: ([\dA-F]+): (?:client|uid)=`
 	queueActiveLine = `^qmgr\[\d+\]: ([\dA-F]+): .* size=(\d{2,12})[, ].+queue active`
 	queueRemoveLine = `^(?:qmgr|postsuper)\[\d+\]: ([\dA-F]+): removed`
 	deliveredLine   = `\[\d+\]: ([\dA-F]+): .+ status=sent`
 	forwardedLine   = `forwarded as `
-	deferredLine    = `\[\d+\]: (?:[\dA-F]+): .+ status=deferred`
-	bouncedLine     = `\[\d+\]: (?:[\dA-F]+): .+ status=bounced`
+	deferredLine    = `\[\d+\]: ([\dA-F]+): .+ status=deferred`
+	bouncedLine     = `\[\d+\]: ([\dA-F]+): .+ status=bounced`
 	rejectLine      = `^(?:(?:s(?:mtps/|ubmission)/)?smtp[ds]|cleanup)\[\d+\]: .*?\breject: `
 	holdLine        = `: NOQUEUE: hold: `
 	discardLine     = `: NOQUEUE: discard: `
@@ -64,64 +71,212 @@ func PostfixCmgHandle(ln net.Listener) {
 	}
 }
 
+// lineKind is what a postfix line means for the message counters
+type lineKind int
+
+const (
+	lineOther lineKind = iota
+	lineReceived
+	lineQueueActive
+	lineRemoved
+	lineForwarded
+	lineDelivered
+	lineBounced
+	lineDeferred
+	lineRejected
+	lineDiscarded
+	lineHeld
+)
+
+// lineStatusKeys are counters of line kinds, queue active and removed
+// lines are not counted
+var lineStatusKeys = [...]string{
+	lineReceived:  "received",
+	lineForwarded: "forwarded",
+	lineDelivered: "delivered",
+	lineBounced:   "bounced",
+	lineDeferred:  "deferred",
+	lineRejected:  "rejected",
+	lineDiscarded: "discarded",
+	lineHeld:      "held",
+}
+
+// postfixLine is a log line classified by classifyPostfixLine
+type postfixLine struct {
+	prefixLen int // syslog prefix length, 0 if it is not a postfix line
+	kind      lineKind
+	queueID   string
+	size      uint64 // message size of a queue active line
+}
+
 func PostfixLineParse(s string) {
+	applyPostfixLine(s, classifyPostfixLine(s))
+}
+
+// classifyPostfixLineRegex finds out the kind of a line by the regular
+// expressions. It is the reference for classifyPostfixLine and is used
+// for lines it does not handle.
+func classifyPostfixLineRegex(s string) postfixLine {
+	var l postfixLine
 	// check if it is postfix line and get log prefix length
-	var logPrefixLen int
 	if sMatch := rePostfixLogLine.FindStringSubmatch(s); sMatch != nil {
-		logPrefixLen = len(sMatch[0])
+		l.prefixLen = len(sMatch[0])
 	} else {
+		return l
+	}
+
+	msg := s[l.prefixLen:]
+	if sMatch := reReceivedLine.FindStringSubmatch(msg); sMatch != nil { // received
+		l.kind, l.queueID = lineReceived, sMatch[1]
+	} else if sMatch := reQueueActiveLine.FindStringSubmatch(msg); sMatch != nil { // queue active
+		l.kind, l.queueID = lineQueueActive, sMatch[1]
+		l.size, _ = strconv.ParseUint(sMatch[2], 10, 64) // no error check after regexp selection
+	} else if sMatch := reQueueRemoveLine.FindStringSubmatch(msg); sMatch != nil { // removed
+		l.kind, l.queueID = lineRemoved, sMatch[1]
+	} else if reForwardedLine.MatchString(msg) { // forwarded
+		l.kind = lineForwarded
+	} else if sMatch := reDeliveredLine.FindStringSubmatch(msg); sMatch != nil { // sent
+		l.kind, l.queueID = lineDelivered, sMatch[1]
+	} else if sMatch := reBouncedLine.FindStringSubmatch(msg); sMatch != nil { // bounced
+		l.kind, l.queueID = lineBounced, sMatch[1]
+	} else if sMatch := reDeferredLine.FindStringSubmatch(msg); sMatch != nil { // deffered
+		l.kind, l.queueID = lineDeferred, sMatch[1]
+	} else if reRejectLine.MatchString(msg) { // rejected
+		l.kind = lineRejected
+	} else if reDiscardLine.MatchString(msg) { // discarded
+		l.kind = lineDiscarded
+	} else if reHoldLine.MatchString(msg) { // held
+		l.kind = lineHeld
+	}
+	return l
+}
+
+// applyPostfixLine updates the counters with a classified line. Lines
+// should be applied in the log order as messages are tracked by queue ID.
+func applyPostfixLine(s string, l postfixLine) {
+	if l.prefixLen == 0 {
+		otherLineParse(s)
+		return
+	}
+	moduleLineParse(s[l.prefixLen:])
+	if !postfixEnabled {
+		return
+	}
+	prefix, msg := s[:l.prefixLen], s[l.prefixLen:]
+	tracker.observe(prefix, msg)
+	defer customLineParse(msg) // custom counters are counted after the built-in ones
+	if postscreenLineParse(msg) || smtpdLineParse(msg) || tlsLineParse(msg) || saslLineParse(prefix, msg) {
 		return
 	}
 
-	var statusKey string
-	if sMatch := reReceivedLine.FindStringSubmatch(s[logPrefixLen:]); sMatch != nil { // received
-		statusKey = "received"
+	filterLoop := false // a content filter hop not counted by the main counters
+	switch l.kind {
+	case lineReceived:
+		reinjected := contentFilter.reinjected(prefix, msg)
 		msgStatusCounters.lock()
-		msgStatusCounters.newRcvMap[sMatch[1]] = true
+		msgStatusCounters.newRcvMap[l.queueID] = true
+		if reinjected {
+			msgStatusCounters.filterRcvMap[l.queueID] = true
+			msgStatusCounters.inc("filter-received", 1)
+		} else {
+			msgStatusCounters.inc("external-received", 1)
+		}
 		msgStatusCounters.unlock()
-	} else if sMatch := reQueueActiveLine.FindStringSubmatch(s[logPrefixLen:]); sMatch != nil { // queue active
-		msgid := sMatch[1]
-		sz, _ := strconv.ParseUint(sMatch[2], 10, 64) // no error check after regexp selection
-
+		if reinjected { // the sender is counted when the message comes in
+			filterLoop = contentFilter.countOnce
+		} else {
+			senderWatch.received(l.queueID, msg)
+		}
+	case lineQueueActive:
 		msgStatusCounters.lock()
-		msgStatusCounters.bytesDlvMap[msgid] = uint64(sz)
-		if msgStatusCounters.newRcvMap[msgid] { // update `bytes-received` counter only once
-			msgStatusCounters.counters["bytes-received"] += sz
-			delete(msgStatusCounters.newRcvMap, msgid)
+		msgStatusCounters.bytesDlvMap[l.queueID] = l.size
+		if msgStatusCounters.newRcvMap[l.queueID] { // update `bytes-received` counter only once
+			if msgStatusCounters.filterRcvMap[l.queueID] {
+				msgStatusCounters.inc("bytes-filter-received", l.size)
+				if !contentFilter.countOnce {
+					msgStatusCounters.inc("bytes-received", l.size)
+				}
+				delete(msgStatusCounters.filterRcvMap, l.queueID)
+			} else {
+				msgStatusCounters.inc("bytes-external-received", l.size)
+				msgStatusCounters.inc("bytes-received", l.size)
+			}
+			delete(msgStatusCounters.newRcvMap, l.queueID)
 		}
 		msgStatusCounters.unlock()
-	} else if sMatch := reQueueRemoveLine.FindStringSubmatch(s[logPrefixLen:]); sMatch != nil { // removed
+		senderWatch.active(l.queueID, prefix, msg)
+	case lineRemoved:
+		msgStatusCounters.lock()
+		delete(msgStatusCounters.bytesDlvMap, l.queueID)
+		msgStatusCounters.unlock()
+		senderWatch.removed(l.queueID)
+	case lineDelivered:
+		toFilter := contentFilter.isFilterRelay(logField(msg, "relay"))
+		filterLoop = toFilter && contentFilter.countOnce
 		msgStatusCounters.lock()
-		delete(msgStatusCounters.bytesDlvMap, sMatch[1])
+		size := msgStatusCounters.bytesDlvMap[l.queueID]
+		if toFilter {
+			msgStatusCounters.inc("filter-delivered", 1)
+			msgStatusCounters.inc("bytes-filter-delivered", size)
+		} else {
+			msgStatusCounters.inc("external-delivered", 1)
+			msgStatusCounters.inc("bytes-external-delivered", size)
+		}
+		if !filterLoop {
+			msgStatusCounters.inc("bytes-delivered", size)
+			transportLineParse("delivered", msg, size)
+		}
 		msgStatusCounters.unlock()
-	} else if reForwardedLine.MatchString(s[logPrefixLen:]) { // forwarded
-		statusKey = "forwarded"
-	} else if sMatch := reDeliveredLine.FindStringSubmatch(s[logPrefixLen:]); sMatch != nil { // sent
-		statusKey = "delivered"
+	case lineBounced, lineDeferred:
 		msgStatusCounters.lock()
-		msgStatusCounters.counters["bytes-delivered"] += msgStatusCounters.bytesDlvMap[sMatch[1]]
+		transportLineParse(lineStatusKeys[l.kind], msg, 0)
 		msgStatusCounters.unlock()
-	} else if reBouncedLine.MatchString(s[logPrefixLen:]) { // bounced
-		statusKey = "bounced"
-	} else if reDeferredLine.MatchString(s[logPrefixLen:]) { // deffered
-		statusKey = "deferred"
-	} else if reRejectLine.MatchString(s[logPrefixLen:]) { // rejected
-		statusKey = "rejected"
-	} else if reDiscardLine.MatchString(s[logPrefixLen:]) { // discarded
-		statusKey = "discarded"
-	} else if reHoldLine.MatchString(s[logPrefixLen:]) { // held
-		statusKey = "held"
+		dsnLineParse(lineStatusKeys[l.kind], msg)
+	case lineRejected:
+		topClients.addReject(msg)
+		senderWatch.cleanupRejected(msg)
+	case lineOther:
+		senderWatch.cleanupRejected(msg) // discard lines
 	}
+
+	statusKey := lineStatusKeys[l.kind]
+	relayWatch.observe(prefix, msg, statusKey)
+	if filterLoop {
+		statusKey = ""
+	}
+	report.observe(prefix, msg, statusKey)
 	if len(statusKey) != 0 {
 		msgStatusCounters.lock()
-		msgStatusCounters.counters[statusKey]++
+		msgStatusCounters.inc(statusKey, 1)
 		msgStatusCounters.unlock()
+		events.publish(PostfixEvent{Type: statusKey, QueueID: l.queueID})
 	}
 }
 
+// postfixDaemon splits a log line after the "postfix/" prefix into the
+// daemon name and the message, "submission/smtpd[123]: ..." is smtpd
+func postfixDaemon(s string) (string, string, bool) {
+	i := strings.Index(s, "]: ")
+	if i < 0 {
+		return "", "", false
+	}
+	daemon := s[:i]
+	if j := strings.IndexByte(daemon, '['); j >= 0 {
+		daemon = daemon[:j]
+	}
+	if j := strings.LastIndexByte(daemon, '/'); j >= 0 {
+		daemon = daemon[j+1:]
+	}
+	return daemon, s[i+3:], true
+}
+
 // PostfixParserInit should be called once at the beginning of work
 func PostfixParserInit(cfg *Config) {
 	msgStatusCounters.reset()
+	msgStatusCounters.totals = make(map[string]uint64, 10)
+	msgStatusCounters.labeledTotals = make(map[string]map[string]uint64)
+	msgStatusCounters.gauges = make(map[string]uint64)
+	contentFilter = newContentFilter(cfg.contentFilter)
 	if cfg.cmd == "tail" {
 		needMx = true
 	}
@@ -135,16 +290,35 @@ func PostfixStats() string {
 
 func (c *MsgStatusCountersType) reset() {
 	c.counters = make(map[string]uint64, 10)
+	c.labeled = make(map[string]map[string]uint64)
 	c.newRcvMap = make(map[string]bool)
+	c.filterRcvMap = make(map[string]bool)
 	c.bytesDlvMap = make(map[string]uint64)
 }
 
+// inc adds v to a counter, the caller should hold the lock
+func (c *MsgStatusCountersType) inc(name string, v uint64) {
+	c.counters[name] += v
+	c.totals[name] += v
+}
+
+// snapshotTotals returns a copy of the never reset counters
+func (c *MsgStatusCountersType) snapshotTotals() map[string]uint64 {
+	c.lock()
+	defer c.unlock()
+	res := make(map[string]uint64, len(c.totals))
+	for k, v := range c.totals {
+		res[k] = v
+	}
+	return res
+}
+
 func (c *MsgStatusCountersType) String() string {
 	var res string
 	for _, s := range PostfixStatusNames {
 		res += fmt.Sprintf("%-16s%d\n", s, c.counters[s])
 	}
-	return res
+	return res + c.groupsString()
 }
 
 func (c *MsgStatusCountersType) lock() {
@@ -162,7 +336,7 @@ func (c *MsgStatusCountersType) unlock() {
 }
 
 func postfixProcessCmd(conn net.Conn) {
-	buf := make([]byte, 32)
+	buf := make([]byte, 512)
 	cnt, err := conn.Read(buf)
 	if err != nil {
 		conn.Close()
@@ -185,9 +359,26 @@ func postfixProcessCmd(conn net.Conn) {
 		msgStatusCounters.lock()
 		msgStatusCounters.reset()
 		msgStatusCounters.unlock()
+	} else if cmd == "rates" {
+		if rates != nil {
+			resp = rates.String()
+		}
+	} else if strings.HasPrefix(cmd, "top ") {
+		resp = topCmd(strings.Fields(cmd)[1:])
+	} else if cmd == "messages" || strings.HasPrefix(cmd, "messages ") {
+		resp = messagesCmd(strings.Fields(cmd)[1:])
+	} else if strings.HasPrefix(cmd, "trace ") {
+		resp = traceCmd(strings.TrimSpace(cmd[6:]))
+	} else if f := strings.Fields(cmd); len(f) == 2 { // windowed sum, e.g. "bounced 15m"
+		window, err := time.ParseDuration(f[1])
+		if err != nil || history == nil {
+			resp = "0\n"
+		} else {
+			resp = fmt.Sprintf("%d\n", history.windowSum(f[0], window))
+		}
 	} else {
 		msgStatusCounters.lock()
-		resp = fmt.Sprintf("%d\n", msgStatusCounters.counters[cmd])
+		resp = fmt.Sprintf("%d\n", msgStatusCounters.value(cmd))
 		msgStatusCounters.unlock()
 	}
 
@@ -24,6 +24,8 @@ func TestPostfixLogLine(t *testing.T) {
 		{"Oct 17 01:55:55 mailserver postfix/local[17781]:", 35},
 		{"Nov 18 01:05:06 mailserver postfix/local[17781]:", 35},
 		{"Dec 19 01:05:07 mailserver postfix-instalnce_name/local[17781]:", 50},
+		{"2025-12-19T01:05:07.123456+03:00 mailserver postfix/local[17781]:", 52},
+		{"2025-12-19T01:05:07Z mailserver postfix/local[17781]:", 40},
 	}
 
 	re, err := regexp.Compile(postfixLogLine)
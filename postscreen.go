@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+)
+
+var postscreenCounters = counterGroup{
+	name: "postscreen",
+	names: []string{"postscreen-connect", "postscreen-pass-new", "postscreen-pass-old",
+		"postscreen-dnsbl", "postscreen-pregreet", "postscreen-hangup",
+		"postscreen-pipelining", "postscreen-non-smtp", "postscreen-bare-newline",
+		"postscreen-command-limit", "postscreen-whitelisted", "postscreen-blacklisted",
+		"postscreen-reject", "postscreen-disconnect"},
+	labeled: []labeledCounter{{name: "postscreen-dnsbl-rank", label: "rank"}},
+}
+
+// postscreenEvents maps the beginning of a postscreen log message to
+// a counter. Postfix 3.6+ uses "ALLOWLISTED" and "DENYLISTED" words.
+var postscreenEvents = []struct {
+	prefix  string
+	counter string
+}{
+	{"CONNECT from ", "postscreen-connect"},
+	{"PASS NEW ", "postscreen-pass-new"},
+	{"PASS OLD ", "postscreen-pass-old"},
+	{"DNSBL rank ", "postscreen-dnsbl"},
+	{"PREGREET ", "postscreen-pregreet"},
+	{"HANGUP ", "postscreen-hangup"},
+	{"COMMAND PIPELINING ", "postscreen-pipelining"},
+	{"NON-SMTP COMMAND ", "postscreen-non-smtp"},
+	{"BARE NEWLINE ", "postscreen-bare-newline"},
+	{"COMMAND TIME LIMIT ", "postscreen-command-limit"},
+	{"COMMAND COUNT LIMIT ", "postscreen-command-limit"},
+	{"COMMAND LENGTH LIMIT ", "postscreen-command-limit"},
+	{"WHITELISTED ", "postscreen-whitelisted"},
+	{"ALLOWLISTED ", "postscreen-whitelisted"},
+	{"BLACKLISTED ", "postscreen-blacklisted"},
+	{"DENYLISTED ", "postscreen-blacklisted"},
+	{"NOQUEUE: reject: ", "postscreen-reject"},
+	{"DISCONNECT ", "postscreen-disconnect"},
+}
+
+// postscreenLineParse counts a postscreen line, s is a log line after
+// the "postfix/" prefix. It returns false if it is not a postscreen line.
+func postscreenLineParse(s string) bool {
+	if !strings.HasPrefix(s, "postscreen[") {
+		return false
+	}
+	i := strings.Index(s, "]: ")
+	if i < 0 {
+		return true
+	}
+	msg := s[i+3:]
+
+	for _, ev := range postscreenEvents {
+		if !strings.HasPrefix(msg, ev.prefix) {
+			continue
+		}
+		msgStatusCounters.lock()
+		msgStatusCounters.inc(ev.counter, 1)
+		if ev.counter == "postscreen-dnsbl" { // "DNSBL rank 3 for [1.2.3.4]:5555"
+			if f := strings.Fields(msg); len(f) > 2 {
+				msgStatusCounters.incLabel("postscreen-dnsbl-rank", f[2], 1)
+			}
+		}
+		msgStatusCounters.unlock()
+		events.publish(PostfixEvent{Type: ev.counter})
+		break
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var postscreenTestLog = []string{
+	"Apr  1 10:00:00 mx postfix/postscreen[900]: CONNECT from [192.0.2.10]:40000 to [198.51.100.1]:25",
+	"Apr  1 10:00:00 mx postfix/postscreen[900]: PASS OLD [192.0.2.10]:40000",
+	"Apr  1 10:00:01 mx postfix/postscreen[900]: CONNECT from [192.0.2.11]:40001 to [198.51.100.1]:25",
+	"Apr  1 10:00:01 mx postfix/postscreen[900]: PREGREET 11 after 0.09 from [192.0.2.11]:40001: EHLO spam\\r\\n",
+	"Apr  1 10:00:01 mx postfix/postscreen[900]: DNSBL rank 3 for [192.0.2.11]:40001",
+	"Apr  1 10:00:02 mx postfix/postscreen[900]: NOQUEUE: reject: RCPT from [192.0.2.11]:40001: 550 5.7.1 Service unavailable; client [192.0.2.11] blocked using zen.spamhaus.org; from=<a@b.c>, to=<d@e.f>, proto=ESMTP, helo=<spam>",
+	"Apr  1 10:00:02 mx postfix/postscreen[900]: DISCONNECT [192.0.2.11]:40001",
+	"Apr  1 10:00:03 mx postfix/postscreen[900]: CONNECT from [192.0.2.12]:40002 to [198.51.100.1]:25",
+	"Apr  1 10:00:03 mx postfix/postscreen[900]: DNSBL rank 1 for [192.0.2.12]:40002",
+	"Apr  1 10:00:03 mx postfix/postscreen[900]: COMMAND PIPELINING from [192.0.2.12]:40002 after EHLO: MAIL FROM:<x@y.z>\\r\\n",
+	"Apr  1 10:00:04 mx postfix/postscreen[900]: HANGUP after 0.5 from [192.0.2.12]:40002 in tests after SMTP handshake",
+	"Apr  1 10:00:05 mx postfix/postscreen[900]: CONNECT from [192.0.2.13]:40003 to [198.51.100.1]:25",
+	"Apr  1 10:00:05 mx postfix/postscreen[900]: ALLOWLISTED [192.0.2.13]:40003",
+	"Apr  1 10:00:06 mx postfix/postscreen[900]: CONNECT from [192.0.2.14]:40004 to [198.51.100.1]:25",
+	"Apr  1 10:00:07 mx postfix/postscreen[900]: PASS NEW [192.0.2.14]:40004",
+	"Apr  1 10:00:08 mx postfix/postscreen[900]: DNSBL rank 3 for [192.0.2.15]:40005",
+}
+
+func TestPostscreenLineParse(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	for _, l := range postscreenTestLog {
+		PostfixLineParse(l)
+	}
+
+	want := map[string]uint64{
+		"postscreen-connect":     5,
+		"postscreen-pass-new":    1,
+		"postscreen-pass-old":    1,
+		"postscreen-dnsbl":       3,
+		"postscreen-pregreet":    1,
+		"postscreen-hangup":      1,
+		"postscreen-pipelining":  1,
+		"postscreen-whitelisted": 1,
+		"postscreen-reject":      1,
+		"postscreen-disconnect":  1,
+		"rejected":               0, // postscreen rejects are counted separately
+	}
+	for name, v := range want {
+		if got := msgStatusCounters.counters[name]; got != v {
+			t.Errorf("Expected %s=%d, got %d", name, v, got)
+		}
+	}
+	ranks := msgStatusCounters.labeled["postscreen-dnsbl-rank"]
+	if ranks["3"] != 2 || ranks["1"] != 1 {
+		t.Errorf("unexpected DNSBL rank distribution %v", ranks)
+	}
+
+	stats := PostfixStats()
+	for _, l := range []string{"postscreen-connect      5\n", "postscreen-dnsbl-rank[3] 2\n"} {
+		if !strings.Contains(stats, l) {
+			t.Errorf("stats output does not contain %q:\n%s", l, stats)
+		}
+	}
+}
+
+func TestPostscreenOutputs(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	for _, l := range postscreenTestLog {
+		PostfixLineParse(l)
+	}
+	msgStatusCounters.reset() // metrics should not be affected
+
+	metrics := metricsString()
+	for _, l := range []string{
+		"mlogtail_received_total 0\n",
+		"mlogtail_postscreen_connect_total 5\n",
+		`mlogtail_postscreen_dnsbl_rank_total{rank="3"} 2` + "\n",
+	} {
+		if !strings.Contains(metrics, l) {
+			t.Errorf("metrics output does not contain %q:\n%s", l, metrics)
+		}
+	}
+
+	PostfixLineParse(postscreenTestLog[0])
+	rr := httptest.NewRecorder()
+	handleStats(rr, httptest.NewRequest("GET", "/stats", nil))
+	var response StatsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if v := response.Groups["postscreen"].Counters["postscreen-connect"]; v != 1 {
+		t.Errorf("Expected postscreen-connect=1 in JSON, got %d", v)
+	}
+
+	rr = httptest.NewRecorder()
+	handleCounter(rr, httptest.NewRequest("GET", "/counter/postscreen-connect", nil))
+	var counter CounterResponse
+	json.Unmarshal(rr.Body.Bytes(), &counter)
+	if counter.Value != 1 {
+		t.Errorf("Expected /counter/postscreen-connect value 1, got %d", counter.Value)
+	}
+}
+
+func TestLabelValuesLimit(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	for i := 0; i < maxLabelValues+10; i++ {
+		msgStatusCounters.incLabel("postscreen-dnsbl-rank", strings.Repeat("1", i+1), 1)
+	}
+	values := msgStatusCounters.labeled["postscreen-dnsbl-rank"]
+	if len(values) != maxLabelValues+1 || values[labelValueOthers] != 10 {
+		t.Errorf("Expected %d label values with 10 others, got %d and %d",
+			maxLabelValues+1, len(values), values[labelValueOthers])
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const ratesInterval = 5 * time.Second // EWMA update interval, as for load average
+
+var rateWindows = [3]time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+// RateValues holds per-second EWMA rates of a counter
+type RateValues struct {
+	M1  float64 `json:"1m"`
+	M5  float64 `json:"5m"`
+	M15 float64 `json:"15m"`
+}
+
+// counterRates computes load average style per-second rates. It uses the
+// never reset counter totals, so rates do not depend on `reset` calls.
+type counterRates struct {
+	sync.Mutex
+	lastTotals map[string]uint64
+	lastTime   time.Time
+	rates      map[string]*[3]float64
+}
+
+var rates *counterRates // nil in file mode
+
+func newCounterRates() *counterRates {
+	return &counterRates{rates: make(map[string]*[3]float64)}
+}
+
+func (r *counterRates) run() {
+	ticker := time.NewTicker(ratesInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		r.update(now)
+	}
+}
+
+func (r *counterRates) update(now time.Time) {
+	totals := msgStatusCounters.snapshotTotals()
+
+	r.Lock()
+	defer r.Unlock()
+	if r.lastTotals != nil {
+		elapsed := now.Sub(r.lastTime).Seconds()
+		if elapsed <= 0 {
+			return
+		}
+		for _, name := range PostfixStatusNames {
+			cur := float64(totals[name]-r.lastTotals[name]) / elapsed
+			ewma, ok := r.rates[name]
+			if !ok {
+				ewma = new([3]float64)
+				r.rates[name] = ewma
+			}
+			for i, w := range rateWindows {
+				e := math.Exp(-elapsed / w.Seconds())
+				ewma[i] = ewma[i]*e + cur*(1-e)
+			}
+		}
+	}
+	r.lastTotals = totals
+	r.lastTime = now
+}
+
+// get returns the current rates of all counters
+func (r *counterRates) get() map[string]RateValues {
+	r.Lock()
+	defer r.Unlock()
+	res := make(map[string]RateValues, len(PostfixStatusNames))
+	for _, name := range PostfixStatusNames {
+		var v RateValues
+		if ewma, ok := r.rates[name]; ok {
+			v = RateValues{M1: ewma[0], M5: ewma[1], M15: ewma[2]}
+		}
+		res[name] = v
+	}
+	return res
+}
+
+func (r *counterRates) String() string {
+	var res string
+	rv := r.get()
+	for _, name := range PostfixStatusNames {
+		v := rv[name]
+		res += fmt.Sprintf("%-16s%.3f %.3f %.3f\n", name, v.M1, v.M5, v.M15)
+	}
+	return res
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCounterRates(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	r := newCounterRates()
+	now := time.Date(2025, 4, 1, 10, 0, 0, 0, time.UTC)
+	r.update(now)
+
+	// a steady flow of 2 deliveries per second for an hour
+	for i := 0; i < 720; i++ {
+		msgStatusCounters.inc("delivered", 10)
+		now = now.Add(ratesInterval)
+		r.update(now)
+	}
+	msgStatusCounters.reset() // should not affect rates
+
+	v := r.get()["delivered"]
+	for _, rate := range []float64{v.M1, v.M5, v.M15} {
+		if math.Abs(rate-2) > 0.2 {
+			t.Errorf("Expected delivered rate about 2/s, got %v", v)
+			break
+		}
+	}
+
+	// the flow stops, the 1m rate should decay faster than 15m one
+	for i := 0; i < 24; i++ {
+		now = now.Add(ratesInterval)
+		r.update(now)
+	}
+	v = r.get()["delivered"]
+	if !(v.M1 < v.M5 && v.M5 < v.M15) {
+		t.Errorf("Expected 1m < 5m < 15m rates after the flow stopped, got %v", v)
+	}
+}
+
+func TestHandleStatsRates(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	rates = newCounterRates()
+	defer func() { rates = nil }()
+	rates.rates["bounced"] = &[3]float64{1.5, 1, 0.5}
+
+	rr := httptest.NewRecorder()
+	handleStats(rr, httptest.NewRequest("GET", "/stats?rates=1", nil))
+
+	var response StatsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if v := response.Rates["bounced"]; v.M1 != 1.5 || v.M5 != 1 || v.M15 != 0.5 {
+		t.Errorf("unexpected bounced rates %v", v)
+	}
+
+	rr = httptest.NewRecorder()
+	handleStats(rr, httptest.NewRequest("GET", "/stats", nil))
+	response = StatsResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response.Rates != nil {
+		t.Error("Rates should be sent on request only")
+	}
+}
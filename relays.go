@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	relaysMaxKeys          = 1000 // relays tracked at once
+	relayDefaultWindow     = 15 * time.Minute
+	relayDefaultMinAttempt = 10
+	relaysEvalInterval     = 10 * time.Second // failing relays without new lines are checked
+)
+
+// RelaysConfig is the "relays" section of the config file: an alert on
+// relays failing too often within the window
+type RelaysConfig struct {
+	Window          jsonDuration   `json:"window"`
+	MaxFailureRatio float64        `json:"max_failure_ratio"` // 0 disables the alert
+	MinAttempts     uint64         `json:"min_attempts"`      // attempts within the window to alert
+	Actions         []*AlertAction `json:"actions,omitempty"`
+}
+
+// RelayStats is a relay in /relays response. Failures are deferrals and
+// connection failures, bounces are mostly about recipients, not relays.
+type RelayStats struct {
+	Relay        string     `json:"relay"` // "mx.example.com[192.0.2.1]:25"
+	Sent         uint64     `json:"sent"`
+	Deferred     uint64     `json:"deferred"`
+	Bounced      uint64     `json:"bounced"`
+	ConnFailures uint64     `json:"connection_failures"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	LastFailure  *time.Time `json:"last_failure,omitempty"`
+	Attempts     uint64     `json:"window_attempts"`
+	FailureRatio float64    `json:"window_failure_ratio"`
+	Failing      bool       `json:"failing"`
+}
+
+// RelaysResponse структура для JSON-ответа /relays
+type RelaysResponse struct {
+	Window string       `json:"window"`
+	Relays []RelayStats `json:"relays"`
+}
+
+// relayWatcher keeps delivery results of smtp and lmtp per relay
+type relayWatcher struct {
+	sync.Mutex
+	cfg      RelaysConfig
+	relays   map[string]*RelayStats // since the start, not reset
+	attempts *windowCounter
+	failures *windowCounter
+}
+
+var relayWatch *relayWatcher // nil in file mode
+
+func newRelayWatcher(cfg RelaysConfig) *relayWatcher {
+	if cfg.Window == 0 {
+		cfg.Window = jsonDuration(relayDefaultWindow)
+	}
+	if cfg.MinAttempts == 0 {
+		cfg.MinAttempts = relayDefaultMinAttempt
+	}
+	return &relayWatcher{
+		cfg:      cfg,
+		relays:   make(map[string]*RelayStats),
+		attempts: newWindowCounter(time.Duration(cfg.Window)),
+		failures: newWindowCounter(time.Duration(cfg.Window)),
+	}
+}
+
+// observe counts smtp and lmtp delivery lines
+// "QID: to=<x@example.com>, relay=mx.example.com[192.0.2.1]:25, ..., status=sent (...)"
+// and connection failures "connect to mx.example.com[192.0.2.1]:25: Connection refused".
+// Deliveries with relay=none are skipped: their connection failure is
+// already counted by its "connect to" line. prefix is the syslog part of
+// the line, s is the rest after "postfix/" and status is the line status key.
+func (w *relayWatcher) observe(prefix, s, status string) {
+	if w == nil {
+		return
+	}
+	daemon, msg, ok := postfixDaemon(strings.TrimRight(s, "\r\n"))
+	if !ok || daemon != "smtp" && daemon != "lmtp" {
+		return
+	}
+
+	var relay string
+	switch status {
+	case "delivered", "deferred", "bounced":
+		if relay = logField(msg, "relay"); relay == "none" {
+			relay = ""
+		}
+	case "":
+		if status, relay = "connect", connectTarget(msg); !strings.HasPrefix(msg, "connect to ") {
+			relay = ""
+		}
+	}
+	if relay == "" {
+		return
+	}
+
+	now := time.Now()
+	ts, ok := logLineTime(prefix, now)
+	if !ok {
+		ts = now
+	}
+	w.Lock()
+	r, ok := w.relays[relay]
+	if !ok {
+		if len(w.relays) >= relaysMaxKeys {
+			w.Unlock()
+			return
+		}
+		r = &RelayStats{Relay: relay}
+		w.relays[relay] = r
+	}
+	failure := false
+	switch status {
+	case "delivered":
+		r.Sent++
+		r.LastSuccess = &ts
+	case "deferred":
+		r.Deferred++
+		failure = true
+	case "bounced":
+		r.Bounced++
+	case "connect":
+		r.ConnFailures++
+		failure = true
+	}
+	w.attempts.add(relay, ts, 0)
+	if failure {
+		r.LastFailure = &ts
+		w.failures.add(relay, ts, 0)
+	}
+	was := r.Failing
+	w.stats(r, now)
+	st := *r
+	w.Unlock()
+
+	if st.Failing != was {
+		w.notify(st, now)
+	}
+}
+
+// connectTarget returns the relay of "connect to mx.example.com[192.0.2.1]:25: ..."
+func connectTarget(msg string) string {
+	i := strings.Index(msg, "connect to ")
+	if i < 0 {
+		return ""
+	}
+	relay, _, ok := strings.Cut(msg[i+11:], ": ")
+	if !ok || !strings.Contains(relay, "[") {
+		return ""
+	}
+	return relay
+}
+
+// stats fills the window values of a relay, the caller should hold the
+// lock. A failing relay is resolved by its next line or by evaluate.
+func (w *relayWatcher) stats(r *RelayStats, now time.Time) {
+	r.Attempts, _ = w.attempts.sum(r.Relay, now)
+	failures, _ := w.failures.sum(r.Relay, now)
+	r.FailureRatio = 0
+	if r.Attempts > 0 {
+		r.FailureRatio = float64(failures) / float64(r.Attempts)
+	}
+	r.Failing = w.cfg.MaxFailureRatio > 0 && r.Attempts >= w.cfg.MinAttempts &&
+		r.FailureRatio > w.cfg.MaxFailureRatio
+}
+
+func (w *relayWatcher) run() {
+	ticker := time.NewTicker(relaysEvalInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		w.evaluate(now)
+	}
+}
+
+// evaluate updates the window values of all the relays and notifies
+// about the ones starting or stopping to fail, e.g. when the traffic to
+// a failing relay stops and its failures leave the window
+func (w *relayWatcher) evaluate(now time.Time) {
+	var changed []RelayStats
+	w.Lock()
+	for _, r := range w.relays {
+		was := r.Failing
+		w.stats(r, now)
+		if r.Failing != was {
+			changed = append(changed, *r)
+		}
+	}
+	w.Unlock()
+	for _, st := range changed {
+		w.notify(st, now)
+	}
+}
+
+// notify reports a relay starting or stopping to fail to the log,
+// /events clients and the configured actions
+func (w *relayWatcher) notify(st RelayStats, now time.Time) {
+	state := alertFiring
+	if !st.Failing {
+		state = alertResolved
+	}
+	fmt.Printf("Relay %s failure ratio is %.2f within %s, it is %s\n",
+		st.Relay, st.FailureRatio, time.Duration(w.cfg.Window), state)
+	events.publish(PostfixEvent{Type: "relay-" + state, Relay: st.Relay})
+
+	p := AlertPayload{Alert: "relay-failure:" + st.Relay, State: state, Value: st.FailureRatio,
+		Op: ">", Threshold: w.cfg.MaxFailureRatio, Since: now, Time: now}
+	p.Host, _ = os.Hostname()
+	for _, a := range w.cfg.Actions {
+		go a.run(p)
+	}
+}
+
+// list returns all the relays, the failing ones and then the most
+// used ones first
+func (w *relayWatcher) list(now time.Time) []RelayStats {
+	w.Lock()
+	defer w.Unlock()
+	res := make([]RelayStats, 0, len(w.relays))
+	for _, r := range w.relays {
+		st := *r
+		w.stats(&st, now)
+		res = append(res, st)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Failing != b.Failing {
+			return a.Failing
+		}
+		if a.Attempts != b.Attempts {
+			return a.Attempts > b.Attempts
+		}
+		return a.Relay < b.Relay
+	})
+	return res
+}
+
+// handleRelays обрабатывает запрос /relays
+func handleRelays(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if relayWatch == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Relays tracking is disabled"})
+		return
+	}
+	json.NewEncoder(w).Encode(RelaysResponse{
+		Window: time.Duration(relayWatch.cfg.Window).String(),
+		Relays: relayWatch.list(time.Now()),
+	})
+}
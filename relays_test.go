@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRelayWatcher(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	relayWatch = newRelayWatcher(RelaysConfig{MaxFailureRatio: 0.5, MinAttempts: 3})
+	defer func() { relayWatch = nil }()
+
+	prefix := time.Now().Format(syslogTimeLayout) + " mail postfix/"
+	mx1, mx2 := "mx1.example.com[198.51.100.1]:25", "mx2.example.net[198.51.100.2]:25"
+	lines := []string{
+		"smtp[200]: 0A2D132D5F: to=<a@example.com>, relay=" + mx1 + ", delay=1, dsn=2.0.0, status=sent (250 Ok)",
+		"smtp[200]: 0A2D132D5F: to=<b@example.com>, relay=" + mx1 + ", delay=1, dsn=5.1.1, status=bounced (550 5.1.1 user unknown)\n",
+		"smtp[201]: 1B2D132D5F: to=<c@example.net>, relay=" + mx2 + ", delay=1, dsn=4.7.0, status=deferred (450 4.7.0 greylisted)",
+		"smtp[201]: connect to " + mx2 + ": Connection timed out",
+		"smtp[201]: 1B2D132D5F: to=<c@example.net>, relay=none, delay=30, dsn=4.4.1, status=deferred (connect to " + mx2 + ": Connection timed out)",
+		"relay/smtp[201]: connect to " + mx2 + ": Connection refused",
+		"lmtp[202]: 2C2D132D5F: to=<d@example.org>, relay=mail.example.org[private/dovecot-lmtp], delay=0.1, dsn=2.0.0, status=sent (250 Ok)",
+		"local[203]: 3D2D132D5F: to=<e@example.org>, relay=local, delay=0.1, dsn=2.0.0, status=sent (delivered to mailbox)",
+		"smtp[204]: 4E2D132D5F: to=<f@example.org>, relay=none, delay=1, dsn=4.4.3, status=deferred (Host or domain name not found)",
+	}
+	for _, l := range lines {
+		PostfixLineParse(prefix + l)
+	}
+
+	relays := relayWatch.list(time.Now())
+	if len(relays) != 3 {
+		t.Fatalf("unexpected relays %+v", relays)
+	}
+	r := relays[0]
+	if r.Relay != mx2 || r.Deferred != 1 || r.ConnFailures != 2 || r.Sent != 0 || r.Attempts != 3 ||
+		r.FailureRatio != 1 || !r.Failing || r.LastFailure == nil || r.LastSuccess != nil {
+		t.Errorf("unexpected failing relay %+v", r)
+	}
+	if r := relays[1]; r.Relay != mx1 || r.Sent != 1 || r.Bounced != 1 || r.FailureRatio != 0 ||
+		r.Failing || r.LastSuccess == nil || r.LastFailure != nil {
+		t.Errorf("unexpected relay %+v", r)
+	}
+	if r := relays[2]; r.Relay != "mail.example.org[private/dovecot-lmtp]" || r.Sent != 1 {
+		t.Errorf("unexpected lmtp relay %+v", r)
+	}
+
+	// the relay recovers
+	for i := 0; i < 5; i++ {
+		PostfixLineParse(prefix + "smtp[205]: 5F2D132D5F: to=<c@example.net>, relay=" + mx2 + ", delay=1, dsn=2.0.0, status=sent (250 Ok)")
+	}
+	if r := relayWatch.relays[mx2]; r.Failing || r.Sent != 5 {
+		t.Errorf("relay is still failing %+v", r)
+	}
+
+	rr := httptest.NewRecorder()
+	handleRelays(rr, httptest.NewRequest("GET", "/relays", nil))
+	var response RelaysResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Window != "15m0s" || len(response.Relays) != 3 || response.Relays[0].Relay != mx2 {
+		t.Errorf("unexpected /relays response %s", rr.Body.String())
+	}
+}
+
+func TestRelayWatcherResolveIdle(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	relayWatch = newRelayWatcher(RelaysConfig{Window: jsonDuration(time.Minute), MaxFailureRatio: 0.5, MinAttempts: 2})
+	defer func() { relayWatch = nil }()
+
+	now := time.Now()
+	mx := "mx.example.com[198.51.100.1]:25"
+	for i := 0; i < 2; i++ {
+		PostfixLineParse(now.Format(syslogTimeLayout) + " mail postfix/smtp[200]: connect to " + mx + ": Connection refused")
+	}
+	if r := relayWatch.relays[mx]; !r.Failing {
+		t.Fatalf("relay is not failing %+v", r)
+	}
+
+	relayWatch.evaluate(now.Add(30 * time.Second))
+	if r := relayWatch.relays[mx]; !r.Failing {
+		t.Errorf("relay is resolved within the window %+v", r)
+	}
+	// no lines for the whole window
+	relayWatch.evaluate(now.Add(2 * time.Minute))
+	if r := relayWatch.relays[mx]; r.Failing || r.Attempts != 0 || r.ConnFailures != 2 {
+		t.Errorf("idle relay is still failing %+v", r)
+	}
+}
+
+func TestHandleRelaysDisabled(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleRelays(rr, httptest.NewRequest("GET", "/relays", nil))
+	if rr.Code != 404 {
+		t.Errorf("Expected 404, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	reportTopN         = 20  // lines in top senders, recipients and domains tables
+	reportMaxReasonLen = 100 // longer reject, defer and bounce reasons are cut
+)
+
+var (
+	reportFormats = []string{"text", "json", "html"}
+	reportTotals  = []string{"senders", "recipients", "sender-domains", "recipient-domains"}
+)
+
+// ReportTraffic is a line of the per-day and per-hour traffic tables
+type ReportTraffic struct {
+	Period    string `json:"period"`
+	Received  uint64 `json:"received"`
+	Delivered uint64 `json:"delivered"`
+	Deferred  uint64 `json:"deferred"`
+	Bounced   uint64 `json:"bounced"`
+	Rejected  uint64 `json:"rejected"`
+}
+
+// ReportVolume is a sender, recipient or domain with its messages and bytes
+type ReportVolume struct {
+	Key      string `json:"key"`
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+	Deferred uint64 `json:"deferred,omitempty"`
+	Bounced  uint64 `json:"bounced,omitempty"`
+}
+
+// ReportReason is a reject, defer or bounce reason with its count.
+// Key is the recipient domain or the reject type ("RCPT", "CONNECT"...).
+type ReportReason struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+	Count  uint64 `json:"count"`
+}
+
+// Report is a traffic summary of a log file like pflogsumm makes
+type Report struct {
+	From              time.Time         `json:"from"`
+	To                time.Time         `json:"to"`
+	Totals            map[string]uint64 `json:"totals"`
+	PerDay            []ReportTraffic   `json:"per_day"`
+	PerHour           []ReportTraffic   `json:"per_hour"`
+	RecipientDomains  []ReportVolume    `json:"recipient_domains"`
+	SenderDomains     []ReportVolume    `json:"sender_domains"`
+	SendersByCount    []ReportVolume    `json:"senders_by_count"`
+	RecipientsByCount []ReportVolume    `json:"recipients_by_count"`
+	SendersBySize     []ReportVolume    `json:"senders_by_size"`
+	RecipientsBySize  []ReportVolume    `json:"recipients_by_size"`
+	Deferrals         []ReportReason    `json:"deferrals"`
+	Bounces           []ReportReason    `json:"bounces"`
+	Rejects           []ReportReason    `json:"rejects"`
+}
+
+type reportMessage struct {
+	sender string
+	size   uint64
+}
+
+// reportCollector gathers everything the counters do not keep while
+// a log file is read by PostfixLineParse
+type reportCollector struct {
+	now              time.Time
+	from, to         time.Time
+	perDay           map[string]*ReportTraffic
+	perHour          [24]ReportTraffic
+	messages         map[string]*reportMessage // by queue ID, until removed
+	senders          map[string]*ReportVolume
+	recipients       map[string]*ReportVolume
+	senderDomains    map[string]*ReportVolume
+	recipientDomains map[string]*ReportVolume
+	deferrals        map[ReportReason]uint64 // Count is always 0 in keys
+	bounces          map[ReportReason]uint64
+	rejects          map[ReportReason]uint64
+}
+
+var report *reportCollector // not nil only for the report command
+
+func newReportCollector() *reportCollector {
+	r := &reportCollector{
+		now:              time.Now(),
+		perDay:           make(map[string]*ReportTraffic),
+		messages:         make(map[string]*reportMessage),
+		senders:          make(map[string]*ReportVolume),
+		recipients:       make(map[string]*ReportVolume),
+		senderDomains:    make(map[string]*ReportVolume),
+		recipientDomains: make(map[string]*ReportVolume),
+		deferrals:        make(map[ReportReason]uint64),
+		bounces:          make(map[ReportReason]uint64),
+		rejects:          make(map[ReportReason]uint64),
+	}
+	for h := range r.perHour {
+		r.perHour[h].Period = fmt.Sprintf("%02d00-%02d00", h, h+1)
+	}
+	return r
+}
+
+// observe is called by PostfixLineParse for every postfix line with
+// the counter the line was classified as, prefix is the syslog part of
+// the line and s is the rest after "postfix/"
+func (r *reportCollector) observe(prefix, s, statusKey string) {
+	if r == nil {
+		return
+	}
+	ts, tsOk := logLineTime(prefix, r.now)
+	if tsOk {
+		if r.from.IsZero() || ts.Before(r.from) {
+			r.from = ts
+		}
+		if ts.After(r.to) {
+			r.to = ts
+		}
+		if statusKey != "" {
+			day := ts.Format("2006-01-02")
+			if r.perDay[day] == nil {
+				r.perDay[day] = &ReportTraffic{Period: day}
+			}
+			r.perDay[day].add(statusKey)
+			r.perHour[ts.Hour()].add(statusKey)
+		}
+	}
+
+	if statusKey == "rejected" {
+		r.rejects[rejectReason(s)]++
+		return
+	}
+	m := reQueueIDLine.FindStringSubmatch(strings.TrimRight(s, "\r\n")) // file mode lines end with a newline
+	if m == nil {
+		return
+	}
+	queueID, rest := m[2], m[3]
+	switch {
+	case strings.HasPrefix(rest, "from="):
+		if r.messages[queueID] != nil { // back from the deferred queue
+			return
+		}
+		msg := &reportMessage{sender: strings.ToLower(logField(rest, "from"))}
+		msg.size, _ = strconv.ParseUint(logField(rest, "size"), 10, 64)
+		r.messages[queueID] = msg
+		if msg.sender == "" {
+			msg.sender = "<>"
+		}
+		volume(r.senders, msg.sender).add(msg.size)
+		volume(r.senderDomains, addressDomain(msg.sender)).add(msg.size)
+	case rest == "removed":
+		delete(r.messages, queueID)
+	case strings.HasPrefix(rest, "to="):
+		rcpt := strings.ToLower(logField(rest, "to"))
+		domain := addressDomain(rcpt)
+		var size uint64
+		if msg := r.messages[queueID]; msg != nil {
+			size = msg.size
+		}
+		switch statusKey {
+		case "delivered":
+			volume(r.recipients, rcpt).add(size)
+			volume(r.recipientDomains, domain).add(size)
+		case "deferred":
+			volume(r.recipientDomains, domain).Deferred++
+			r.deferrals[ReportReason{Key: domain, Reason: statusDetail(rest)}]++
+		case "bounced":
+			volume(r.recipientDomains, domain).Bounced++
+			r.bounces[ReportReason{Key: domain, Reason: statusDetail(rest)}]++
+		}
+	}
+}
+
+func (t *ReportTraffic) add(statusKey string) {
+	switch statusKey {
+	case "received":
+		t.Received++
+	case "delivered":
+		t.Delivered++
+	case "deferred":
+		t.Deferred++
+	case "bounced":
+		t.Bounced++
+	case "rejected":
+		t.Rejected++
+	}
+}
+
+func volume(m map[string]*ReportVolume, key string) *ReportVolume {
+	v := m[key]
+	if v == nil {
+		v = &ReportVolume{Key: key}
+		m[key] = v
+	}
+	return v
+}
+
+func (v *ReportVolume) add(size uint64) {
+	v.Messages++
+	v.Bytes += size
+}
+
+func addressDomain(addr string) string {
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		return addr[i+1:]
+	}
+	return addr
+}
+
+// statusDetail returns the text in parentheses after "status=deferred"
+func statusDetail(s string) string {
+	i := strings.Index(s, "status=")
+	if i < 0 {
+		return ""
+	}
+	j := strings.Index(s[i:], " (")
+	if j < 0 {
+		return ""
+	}
+	return shortReason(strings.TrimSuffix(s[i+j+2:], ")"))
+}
+
+// rejectReason splits a reject line like "NOQUEUE: reject: RCPT from
+// unknown[192.0.2.1]: 554 5.7.1 <x@example.com>: Relay access denied;
+// from=..." into its type ("RCPT") and reason ("Relay access denied")
+func rejectReason(s string) ReportReason {
+	i := strings.Index(s, "reject: ")
+	if i < 0 {
+		return ReportReason{}
+	}
+	s = s[i+8:]
+	var res ReportReason
+	res.Key, s, _ = strings.Cut(s, " ")
+	if i = strings.Index(s, "]: "); i >= 0 {
+		s = s[i+3:]
+	}
+	if f := strings.SplitN(s, " ", 3); len(f) == 3 && len(f[0]) == 3 && strings.Count(f[1], ".") == 2 {
+		s = f[2] // SMTP and enhanced status codes
+	}
+	if strings.HasPrefix(s, "<") {
+		if i = strings.Index(s, ">: "); i >= 0 {
+			s = s[i+3:]
+		}
+	}
+	if i = strings.Index(s, "; from="); i >= 0 {
+		s = s[:i]
+	}
+	res.Reason = shortReason(s)
+	return res
+}
+
+func shortReason(s string) string {
+	if len(s) > reportMaxReasonLen {
+		return s[:reportMaxReasonLen] + "..."
+	}
+	return s
+}
+
+// build makes the report from the collected data and the counters
+func (r *reportCollector) build() *Report {
+	res := &Report{From: r.from, To: r.to, Totals: make(map[string]uint64)}
+	for _, name := range PostfixStatusNames {
+		res.Totals[name] = msgStatusCounters.counters[name]
+	}
+	res.Totals["senders"] = uint64(len(r.senders))
+	res.Totals["recipients"] = uint64(len(r.recipients))
+	res.Totals["sender-domains"] = uint64(len(r.senderDomains))
+	res.Totals["recipient-domains"] = uint64(len(r.recipientDomains))
+
+	res.PerDay = []ReportTraffic{}
+	for _, day := range sortedReportKeys(r.perDay) {
+		res.PerDay = append(res.PerDay, *r.perDay[day])
+	}
+	res.PerHour = r.perHour[:]
+
+	res.RecipientDomains = topVolumes(r.recipientDomains, false)
+	res.SenderDomains = topVolumes(r.senderDomains, false)
+	res.SendersByCount = topVolumes(r.senders, false)
+	res.RecipientsByCount = topVolumes(r.recipients, false)
+	res.SendersBySize = topVolumes(r.senders, true)
+	res.RecipientsBySize = topVolumes(r.recipients, true)
+	res.Deferrals = sortedReasons(r.deferrals)
+	res.Bounces = sortedReasons(r.bounces)
+	res.Rejects = sortedReasons(r.rejects)
+	return res
+}
+
+func sortedReportKeys(m map[string]*ReportTraffic) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// topVolumes returns reportTopN keys with the most messages or bytes
+func topVolumes(m map[string]*ReportVolume, bySize bool) []ReportVolume {
+	res := make([]ReportVolume, 0, len(m))
+	for _, v := range m {
+		if v.Messages > 0 || !bySize {
+			res = append(res, *v)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i].Messages, res[j].Messages
+		if bySize {
+			a, b = res[i].Bytes, res[j].Bytes
+		}
+		return a > b || a == b && res[i].Key < res[j].Key
+	})
+	if len(res) > reportTopN {
+		res = res[:reportTopN]
+	}
+	return res
+}
+
+func sortedReasons(m map[ReportReason]uint64) []ReportReason {
+	res := make([]ReportReason, 0, len(m))
+	for r, cnt := range m {
+		r.Count = cnt
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Key != res[j].Key {
+			return res[i].Key < res[j].Key
+		}
+		return res[i].Count > res[j].Count || res[i].Count == res[j].Count && res[i].Reason < res[j].Reason
+	})
+	return res
+}
+
+// render formats the report as text, json or html
+func (r *Report) render(format string) (string, error) {
+	switch format {
+	case "text":
+		return r.String(), nil
+	case "json":
+		data, err := json.MarshalIndent(r, "", "  ")
+		return string(data) + "\n", err
+	case "html":
+		var b strings.Builder
+		err := reportHTML.Execute(&b, r)
+		return b.String(), err
+	}
+	return "", fmt.Errorf("Unknown report format %q, it can be one of %s", format, strings.Join(reportFormats, ", "))
+}
+
+func (r *Report) String() string {
+	const tf = "2006-01-02 15:04:05"
+	var b strings.Builder
+	if !r.From.IsZero() {
+		fmt.Fprintf(&b, "Postfix log summary from %s to %s\n", r.From.Format(tf), r.To.Format(tf))
+	}
+
+	reportHeader(&b, "Grand Totals")
+	for _, name := range append(PostfixStatusNames[:], reportTotals...) {
+		fmt.Fprintf(&b, "%-20s%d\n", name, r.Totals[name])
+	}
+
+	for _, t := range []struct {
+		title string
+		lines []ReportTraffic
+	}{{"Per-Day Traffic Summary", r.PerDay}, {"Per-Hour Traffic Summary", r.PerHour}} {
+		reportHeader(&b, t.title)
+		fmt.Fprintf(&b, "%-12s%10s%10s%10s%10s%10s\n", "", "received", "delivered", "deferred", "bounced", "rejected")
+		for _, l := range t.lines {
+			fmt.Fprintf(&b, "%-12s%10d%10d%10d%10d%10d\n", l.Period, l.Received, l.Delivered, l.Deferred, l.Bounced, l.Rejected)
+		}
+	}
+
+	reportHeader(&b, "Host/Domain Summary: Message Delivery")
+	fmt.Fprintf(&b, "%8s%12s%10s%10s  %s\n", "sent", "bytes", "deferred", "bounced", "domain")
+	for _, v := range r.RecipientDomains {
+		fmt.Fprintf(&b, "%8d%12d%10d%10d  %s\n", v.Messages, v.Bytes, v.Deferred, v.Bounced, v.Key)
+	}
+
+	for _, t := range []struct {
+		title string
+		lines []ReportVolume
+	}{
+		{"Host/Domain Summary: Messages Received", r.SenderDomains},
+		{"Senders by message count", r.SendersByCount},
+		{"Recipients by message count", r.RecipientsByCount},
+		{"Senders by message size", r.SendersBySize},
+		{"Recipients by message size", r.RecipientsBySize},
+	} {
+		reportHeader(&b, t.title)
+		fmt.Fprintf(&b, "%8s%12s\n", "msgs", "bytes")
+		for _, v := range t.lines {
+			fmt.Fprintf(&b, "%8d%12d  %s\n", v.Messages, v.Bytes, v.Key)
+		}
+	}
+
+	for _, t := range []struct {
+		title string
+		lines []ReportReason
+	}{{"Message deferral detail", r.Deferrals}, {"Message bounce detail", r.Bounces}, {"Message reject detail", r.Rejects}} {
+		reportHeader(&b, t.title)
+		key := ""
+		for _, rr := range t.lines {
+			if rr.Key != key {
+				key = rr.Key
+				fmt.Fprintf(&b, "  %s\n", key)
+			}
+			fmt.Fprintf(&b, "%8d  %s\n", rr.Count, rr.Reason)
+		}
+		if len(t.lines) == 0 {
+			b.WriteString("  none\n")
+		}
+	}
+	return b.String()
+}
+
+func reportHeader(b *strings.Builder, title string) {
+	fmt.Fprintf(b, "\n%s\n%s\n", title, strings.Repeat("-", len(title)))
+}
+
+var reportHTML = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Postfix log summary</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; }
+td.n { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Postfix log summary</h1>
+{{if not .From.IsZero}}<p>{{.From.Format "2006-01-02 15:04:05"}} &ndash; {{.To.Format "2006-01-02 15:04:05"}}</p>{{end}}
+<h2>Grand Totals</h2>
+<table>
+{{range $k, $v := .Totals}}<tr><td>{{$k}}</td><td class="n">{{$v}}</td></tr>
+{{end}}</table>
+{{define "traffic"}}<table>
+<tr><th></th><th>received</th><th>delivered</th><th>deferred</th><th>bounced</th><th>rejected</th></tr>
+{{range .}}<tr><td>{{.Period}}</td><td class="n">{{.Received}}</td><td class="n">{{.Delivered}}</td><td class="n">{{.Deferred}}</td><td class="n">{{.Bounced}}</td><td class="n">{{.Rejected}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Per-Day Traffic Summary</h2>
+{{template "traffic" .PerDay}}
+<h2>Per-Hour Traffic Summary</h2>
+{{template "traffic" .PerHour}}
+<h2>Host/Domain Summary: Message Delivery</h2>
+<table>
+<tr><th>sent</th><th>bytes</th><th>deferred</th><th>bounced</th><th>domain</th></tr>
+{{range .RecipientDomains}}<tr><td class="n">{{.Messages}}</td><td class="n">{{.Bytes}}</td><td class="n">{{.Deferred}}</td><td class="n">{{.Bounced}}</td><td>{{.Key}}</td></tr>
+{{end}}</table>
+{{define "volume"}}<table>
+<tr><th>msgs</th><th>bytes</th><th></th></tr>
+{{range .}}<tr><td class="n">{{.Messages}}</td><td class="n">{{.Bytes}}</td><td>{{.Key}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Host/Domain Summary: Messages Received</h2>
+{{template "volume" .SenderDomains}}
+<h2>Senders by message count</h2>
+{{template "volume" .SendersByCount}}
+<h2>Recipients by message count</h2>
+{{template "volume" .RecipientsByCount}}
+<h2>Senders by message size</h2>
+{{template "volume" .SendersBySize}}
+<h2>Recipients by message size</h2>
+{{template "volume" .RecipientsBySize}}
+{{define "reasons"}}<table>
+<tr><th></th><th>count</th><th>reason</th></tr>
+{{range .}}<tr><td>{{.Key}}</td><td class="n">{{.Count}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Message deferral detail</h2>
+{{template "reasons" .Deferrals}}
+<h2>Message bounce detail</h2>
+{{template "reasons" .Bounces}}
+<h2>Message reject detail</h2>
+{{template "reasons" .Rejects}}
+</body>
+</html>
+`))
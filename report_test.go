@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	report = newReportCollector()
+	defer func() { report = nil }()
+
+	log := append([]string{}, trackerTestLog...)
+	log = append(log,
+		"Apr  1 11:00:00 mail postfix/pickup[1010]: BC12345678: uid=1000 from=<root>",
+		"Apr  1 11:00:01 mail postfix/qmgr[1002]: BC12345678: from=<root@mail.example.com>, size=512, nrcpt=1 (queue active)\n", // as read in file mode
+		"Apr  1 11:00:02 mail postfix/smtp[1011]: BC12345678: to=<dave@example.net>, relay=mx.example.net[1.2.3.4]:25, delay=1, delays=0/0/0/1, dsn=5.1.1, status=bounced (host mx.example.net[1.2.3.4] said: 550 5.1.1 User unknown (in reply to RCPT TO command))",
+		"Apr  1 11:00:02 mail postfix/qmgr[1002]: BC12345678: removed",
+		"Apr  2 09:15:00 mail postfix/smtpd[1012]: NOQUEUE: reject: RCPT from unknown[192.0.2.1]: 554 5.7.1 <x@example.com>: Relay access denied; from=<a@b.c> to=<x@example.com> proto=ESMTP helo=<spam>",
+		"Apr  2 09:16:00 mail postfix/smtpd[1012]: NOQUEUE: reject: RCPT from unknown[192.0.2.2]: 554 5.7.1 <y@example.com>: Relay access denied; from=<a@b.c> to=<y@example.com> proto=ESMTP helo=<spam>",
+	)
+	for _, l := range log {
+		PostfixLineParse(l)
+	}
+	r := report.build()
+
+	if r.Totals["received"] != 2 || r.Totals["delivered"] != 2 || r.Totals["senders"] != 2 ||
+		r.Totals["recipients"] != 2 || r.Totals["recipient-domains"] != 2 {
+		t.Errorf("unexpected totals %v", r.Totals)
+	}
+	if len(r.PerDay) != 2 || r.PerDay[0].Received != 2 || r.PerDay[0].Bounced != 1 || r.PerDay[1].Rejected != 2 {
+		t.Errorf("unexpected per-day traffic %+v", r.PerDay)
+	}
+	if h := r.PerHour[10]; h.Period != "1000-1100" || h.Delivered != 2 || h.Deferred != 1 {
+		t.Errorf("unexpected per-hour traffic %+v", h)
+	}
+	if len(r.RecipientDomains) != 2 || r.RecipientDomains[0].Key != "example.net" ||
+		r.RecipientDomains[0].Messages != 1 || r.RecipientDomains[0].Bounced != 1 || r.RecipientDomains[0].Bytes != 2048 {
+		t.Errorf("unexpected recipient domains %+v", r.RecipientDomains)
+	}
+	if r.SendersBySize[0].Key != "alice@example.com" || r.SendersBySize[0].Bytes != 2048 {
+		t.Errorf("unexpected senders %+v", r.SendersBySize)
+	}
+	want := ReportReason{Key: "example.org", Reason: "connect to mx.example.org[5.6.7.8]:25: Connection timed out", Count: 1}
+	if len(r.Deferrals) != 1 || r.Deferrals[0] != want {
+		t.Errorf("unexpected deferrals %+v", r.Deferrals)
+	}
+	want = ReportReason{Key: "RCPT", Reason: "Relay access denied", Count: 2}
+	if len(r.Rejects) != 1 || r.Rejects[0] != want {
+		t.Errorf("unexpected rejects %+v", r.Rejects)
+	}
+
+	text, _ := r.render("text")
+	if !strings.Contains(text, "\nrecipient-domains   2\n") || !strings.Contains(text, "  RCPT\n       2  Relay access denied\n") {
+		t.Errorf("unexpected text report:\n%s", text)
+	}
+	data, _ := r.render("json")
+	var decoded Report
+	if err := json.Unmarshal([]byte(data), &decoded); err != nil || decoded.Totals["bounced"] != 1 {
+		t.Errorf("unexpected JSON report %v: %s", err, data)
+	}
+	html, err := r.render("html")
+	if err != nil || !strings.Contains(html, "<td>alice@example.com</td>") {
+		t.Errorf("unexpected HTML report %v: %s", err, html)
+	}
+	if _, err := r.render("xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestRejectReason(t *testing.T) {
+	tests := map[string]ReportReason{
+		"smtpd[1]: NOQUEUE: reject: CONNECT from unknown[192.0.2.1]: 554 5.7.1 Service unavailable; Client host [192.0.2.1] blocked using zen.spamhaus.org; from=<a@b.c> proto=ESMTP": {
+			Key: "CONNECT", Reason: "Service unavailable; Client host [192.0.2.1] blocked using zen.spamhaus.org"},
+		"cleanup[2]: AD59432D65: reject: header Subject: spam from mail.example.net[198.51.100.7]; from=<a@example.net> to=<b@example.com> proto=ESMTP helo=<mail.example.net>: 5.7.1 Spam": {
+			Key: "header", Reason: "Subject: spam from mail.example.net[198.51.100.7]"},
+	}
+	for s, want := range tests {
+		if got := rejectReason(s); got != want {
+			t.Errorf("rejectReason(%q) = %+v, want %+v", s, got, want)
+		}
+	}
+}
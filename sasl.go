@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	authWatchMaxKeys   = 10000 // client IPs and usernames tracked at once
+	authWatchMaxEvents = 10000 // events kept per key
+	authTopDefault     = 10
+)
+
+var saslCounters = counterGroup{
+	name:  "sasl",
+	names: []string{"sasl-success", "sasl-failed"},
+	labeled: []labeledCounter{
+		{name: "sasl-success-method", label: "method"},
+		{name: "sasl-failed-method", label: "method"},
+	},
+}
+
+// slidingCounter keeps event times of keys (client IPs, usernames) for
+// a sliding window
+type slidingCounter struct {
+	window     time.Duration
+	events     map[string][]time.Time
+	lastExpire time.Time
+}
+
+// AuthClient is a client IP with its SASL failures within the window
+type AuthClient struct {
+	Client   string    `json:"client"`
+	Failures int       `json:"failures"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// AuthUser is a SASL username with its successful logins within the window
+type AuthUser struct {
+	Username string    `json:"username"`
+	Logins   int       `json:"logins"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// AuthTopResponse структура для JSON-ответа /auth/top
+type AuthTopResponse struct {
+	Window      string       `json:"window"`
+	FailingIPs  []AuthClient `json:"failing_clients"`
+	ActiveUsers []AuthUser   `json:"users"`
+}
+
+// authWatcher tracks SASL failures per client IP and logins per
+// username to find brute-force attacks
+type authWatcher struct {
+	sync.Mutex
+	threshold int // failures within the window making a client suspicious
+	failures  *slidingCounter
+	logins    *slidingCounter
+}
+
+var authWatch *authWatcher // nil in file mode
+
+func newSlidingCounter(window time.Duration) *slidingCounter {
+	return &slidingCounter{window: window, events: make(map[string][]time.Time)}
+}
+
+// add counts an event, the events of the key out of the window are
+// dropped and all the keys are expired once per window, so the memory
+// does not grow if nobody requests the top
+func (sc *slidingCounter) add(key string, ts time.Time) {
+	if ts.Sub(sc.lastExpire) >= sc.window {
+		sc.expire(ts)
+	}
+	ev, ok := sc.events[key]
+	if !ok && len(sc.events) >= authWatchMaxKeys {
+		sc.expire(ts)
+		if len(sc.events) >= authWatchMaxKeys {
+			return
+		}
+	}
+	from := ts.Add(-sc.window)
+	ev = ev[sort.Search(len(ev), func(i int) bool { return ev[i].After(from) }):]
+	if len(ev) >= authWatchMaxEvents {
+		ev = ev[1:]
+	}
+	sc.events[key] = append(ev, ts)
+}
+
+// expire forgets events out of the window
+func (sc *slidingCounter) expire(now time.Time) {
+	sc.lastExpire = now
+	from := now.Add(-sc.window)
+	for key, ev := range sc.events {
+		i := sort.Search(len(ev), func(i int) bool { return ev[i].After(from) })
+		if i == len(ev) {
+			delete(sc.events, key)
+		} else if i > 0 {
+			sc.events[key] = append([]time.Time{}, ev[i:]...)
+		}
+	}
+}
+
+// top returns keys with at least min events, the most active first
+func (sc *slidingCounter) top(now time.Time, min, n int) []string {
+	sc.expire(now)
+	var res []string
+	for key, ev := range sc.events {
+		if len(ev) >= min {
+			res = append(res, key)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := len(sc.events[res[i]]), len(sc.events[res[j]])
+		return a > b || a == b && res[i] < res[j]
+	})
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+func newAuthWatcher(window time.Duration, threshold int) *authWatcher {
+	return &authWatcher{
+		threshold: threshold,
+		failures:  newSlidingCounter(window),
+		logins:    newSlidingCounter(window),
+	}
+}
+
+func (w *authWatcher) failure(client string, ts time.Time) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	w.failures.add(client, ts)
+	w.Unlock()
+}
+
+func (w *authWatcher) login(username string, ts time.Time) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	w.logins.add(username, ts)
+	w.Unlock()
+}
+
+func (w *authWatcher) clients(now time.Time, min, n int) []AuthClient {
+	w.Lock()
+	defer w.Unlock()
+	res := []AuthClient{}
+	for _, c := range w.failures.top(now, min, n) {
+		ev := w.failures.events[c]
+		res = append(res, AuthClient{Client: c, Failures: len(ev), LastSeen: ev[len(ev)-1]})
+	}
+	return res
+}
+
+func (w *authWatcher) users(now time.Time, n int) []AuthUser {
+	w.Lock()
+	defer w.Unlock()
+	res := []AuthUser{}
+	for _, u := range w.logins.top(now, 1, n) {
+		ev := w.logins.events[u]
+		res = append(res, AuthUser{Username: u, Logins: len(ev), LastSeen: ev[len(ev)-1]})
+	}
+	return res
+}
+
+// saslLineParse counts SASL logins from smtpd client lines
+// "QID: client=host[1.2.3.4], sasl_method=PLAIN, sasl_username=user@example.com"
+// and failures "warning: unknown[1.2.3.4]: SASL LOGIN authentication failed: ...".
+// prefix is the syslog part of the line, s is the rest after "postfix/".
+// It returns false if the line should be classified further.
+func saslLineParse(prefix, s string) bool {
+	daemon, msg, ok := postfixDaemon(s)
+	if !ok || daemon != "smtpd" {
+		return false
+	}
+
+	if strings.HasPrefix(msg, "warning: ") && strings.Contains(msg, " authentication failed") {
+		msg = msg[9:]
+		client, rest, _ := strings.Cut(msg, ": ")
+		f := strings.Fields(rest) // "SASL LOGIN authentication failed: ..."
+		if len(f) < 3 || f[0] != "SASL" {
+			return false
+		}
+		msgStatusCounters.lock()
+		msgStatusCounters.inc("sasl-failed", 1)
+		msgStatusCounters.incLabel("sasl-failed-method", f[1], 1)
+		msgStatusCounters.unlock()
+		if authWatch != nil {
+			authWatch.failure(clientIP(client), authEventTime(prefix))
+		}
+		return true
+	}
+
+	if i := strings.Index(msg, ", sasl_method="); i > 0 && strings.Contains(msg[:i], "client=") {
+		method := logField(msg, "sasl_method")
+		msgStatusCounters.lock()
+		msgStatusCounters.inc("sasl-success", 1)
+		msgStatusCounters.incLabel("sasl-success-method", method, 1)
+		msgStatusCounters.unlock()
+		if username := logField(msg, "sasl_username"); authWatch != nil && username != "" {
+			authWatch.login(username, authEventTime(prefix))
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of "host[1.2.3.4]"
+func clientIP(client string) string {
+	if i := strings.IndexByte(client, '['); i >= 0 {
+		if j := strings.IndexByte(client[i:], ']'); j > 0 {
+			return client[i+1 : i+j]
+		}
+	}
+	return client
+}
+
+func authEventTime(prefix string) time.Time {
+	now := time.Now()
+	if ts, ok := logLineTime(prefix, now); ok {
+		return ts
+	}
+	return now
+}
+
+func authTopN(r *http.Request) int {
+	if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n > 0 {
+		return n
+	}
+	return authTopDefault
+}
+
+// handleAuthSuspicious обрабатывает запрос /auth/suspicious
+func handleAuthSuspicious(w http.ResponseWriter, r *http.Request) {
+	if authWatch == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Authentication watching is disabled"})
+		return
+	}
+
+	clients := authWatch.clients(time.Now(), authWatch.threshold, 0)
+	if r.URL.Query().Get("format") == "text" { // one IP per line for fail2ban-like tools
+		w.Header().Set("Content-Type", "text/plain")
+		for _, c := range clients {
+			fmt.Fprintln(w, c.Client)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
+
+// handleAuthTop обрабатывает запрос /auth/top
+func handleAuthTop(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if authWatch == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Authentication watching is disabled"})
+		return
+	}
+
+	n, now := authTopN(r), time.Now()
+	json.NewEncoder(w).Encode(AuthTopResponse{
+		Window:      authWatch.failures.window.String(),
+		FailingIPs:  authWatch.clients(now, 1, n),
+		ActiveUsers: authWatch.users(now, n),
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSaslLineParse(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	authWatch = newAuthWatcher(10*time.Minute, 3)
+	defer func() { authWatch = nil }()
+
+	prefix := time.Now().Format(syslogTimeLayout) + " mail postfix/"
+	old := time.Now().Add(-time.Hour).Format(syslogTimeLayout) + " mail postfix/"
+	lines := []string{
+		prefix + "submission/smtpd[100]: AD59432D65: client=mail.client.int[172.30.0.1], sasl_method=PLAIN, sasl_username=mail@client.int",
+		prefix + "submission/smtpd[100]: 0B1C2D3E4F: client=mail.client.int[172.30.0.1], sasl_method=LOGIN, sasl_username=mail@client.int",
+		prefix + "submission/smtpd[101]: 1A2B3C4D5E: client=pc.client.int[172.30.0.2], sasl_method=PLAIN, sasl_username=bob@client.int",
+		old + "submission/smtpd[102]: warning: unknown[192.0.2.66]: SASL LOGIN authentication failed: UGFzc3dvcmQ6",
+		prefix + "submission/smtpd[102]: warning: unknown[192.0.2.66]: SASL LOGIN authentication failed: UGFzc3dvcmQ6",
+		prefix + "submission/smtpd[102]: warning: unknown[192.0.2.66]: SASL LOGIN authentication failed: UGFzc3dvcmQ6",
+		prefix + "submission/smtpd[102]: warning: unknown[192.0.2.66]: SASL PLAIN authentication failed: authentication failure, sasl_username=admin",
+		prefix + "submission/smtpd[103]: warning: unknown[192.0.2.77]: SASL LOGIN authentication failed: UGFzc3dvcmQ6",
+	}
+	for _, l := range lines {
+		PostfixLineParse(l)
+	}
+
+	want := map[string]uint64{"sasl-success": 3, "sasl-failed": 5, "received": 3}
+	for name, v := range want {
+		if got := msgStatusCounters.counters[name]; got != v {
+			t.Errorf("Expected %s=%d, got %d", name, v, got)
+		}
+	}
+	if m := msgStatusCounters.labeled["sasl-failed-method"]; m["LOGIN"] != 4 || m["PLAIN"] != 1 {
+		t.Errorf("unexpected sasl-failed-method %v", m)
+	}
+	if m := msgStatusCounters.labeled["sasl-success-method"]; m["LOGIN"] != 1 || m["PLAIN"] != 2 {
+		t.Errorf("unexpected sasl-success-method %v", m)
+	}
+
+	// the failure an hour ago is out of the window
+	suspicious := authWatch.clients(time.Now(), authWatch.threshold, 0)
+	if len(suspicious) != 1 || suspicious[0].Client != "192.0.2.66" || suspicious[0].Failures != 3 {
+		t.Errorf("unexpected suspicious clients %+v", suspicious)
+	}
+	users := authWatch.users(time.Now(), 10)
+	if len(users) != 2 || users[0].Username != "mail@client.int" || users[0].Logins != 2 {
+		t.Errorf("unexpected top users %+v", users)
+	}
+
+	rr := httptest.NewRecorder()
+	handleAuthSuspicious(rr, httptest.NewRequest("GET", "/auth/suspicious?format=text", nil))
+	if rr.Body.String() != "192.0.2.66\n" {
+		t.Errorf("unexpected /auth/suspicious output %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleAuthTop(rr, httptest.NewRequest("GET", "/auth/top?n=1", nil))
+	var top AuthTopResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &top); err != nil {
+		t.Fatal(err)
+	}
+	if len(top.FailingIPs) != 1 || len(top.ActiveUsers) != 1 {
+		t.Errorf("unexpected /auth/top response %+v", top)
+	}
+}
+
+func TestSlidingCounterLimits(t *testing.T) {
+	sc := newSlidingCounter(time.Minute)
+	now := time.Now()
+	for i := 0; i < authWatchMaxKeys; i++ {
+		sc.add(strconv.Itoa(i), now.Add(-2*time.Minute))
+	}
+	sc.add("new", now) // old keys are expired to make room
+	if len(sc.events) != 1 || len(sc.events["new"]) != 1 {
+		t.Errorf("Expected only the new key, got %d keys", len(sc.events))
+	}
+}
+
+func TestSlidingCounterExpireOnAdd(t *testing.T) {
+	sc := newSlidingCounter(time.Minute)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		sc.add("192.0.2.1", now)
+		sc.add("192.0.2.2", now)
+	}
+	// no top requests, the events are dropped by the next lines
+	later := now.Add(90 * time.Second)
+	sc.add("192.0.2.1", later)
+	if n := len(sc.events["192.0.2.1"]); n != 1 {
+		t.Errorf("Expected 1 event in the window, got %d", n)
+	}
+	if _, ok := sc.events["192.0.2.2"]; ok {
+		t.Error("idle key is not expired")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	searchDefaultLimit = 50
+	searchMaxLimit     = 1000
+)
+
+var searchParams = []string{"from", "to", "client", "status", "since", "limit", "offset"}
+
+// MessageSummary is a short form of TrackedMessage for search results
+type MessageSummary struct {
+	QueueID    string     `json:"queue_id"`
+	FirstSeen  time.Time  `json:"first_seen"`
+	Client     string     `json:"client,omitempty"`
+	Sender     string     `json:"from"`
+	Recipients []string   `json:"recipients"`
+	Size       uint64     `json:"size"`
+	Status     string     `json:"status"`
+	Removed    *time.Time `json:"removed,omitempty"`
+}
+
+// MessagesResponse структура для JSON-ответа /messages
+type MessagesResponse struct {
+	Total    int              `json:"total"`
+	Offset   int              `json:"offset"`
+	Limit    int              `json:"limit"`
+	Messages []MessageSummary `json:"messages"`
+}
+
+// messageQuery is a search request, string fields are case insensitive
+// substrings, an empty field matches everything
+type messageQuery struct {
+	from, to, client, status string
+	since                    time.Time
+	limit, offset            int
+}
+
+// newMessageQuery takes search parameters from an HTTP query or from
+// the "messages" socket command arguments
+func newMessageQuery(v url.Values, now time.Time) (*messageQuery, error) {
+	for k := range v {
+		if !strArrayLookup(searchParams, k) {
+			return nil, fmt.Errorf("Unknown parameter %q", k)
+		}
+	}
+	q := &messageQuery{
+		from:   strings.ToLower(v.Get("from")),
+		to:     strings.ToLower(v.Get("to")),
+		client: strings.ToLower(v.Get("client")),
+		status: v.Get("status"),
+		limit:  searchDefaultLimit,
+	}
+	var err error
+	if s := v.Get("since"); s != "" {
+		if q.since, err = parseHistoryTime(s, now); err != nil {
+			return nil, err
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.limit, err = strconv.Atoi(s); err != nil || q.limit < 1 || q.limit > searchMaxLimit {
+			return nil, fmt.Errorf("Limit should be from 1 to %d", searchMaxLimit)
+		}
+	}
+	if s := v.Get("offset"); s != "" {
+		if q.offset, err = strconv.Atoi(s); err != nil || q.offset < 0 {
+			return nil, fmt.Errorf("Incorrect offset %q", s)
+		}
+	}
+	return q, nil
+}
+
+// status returns the message delivery status: "bounced" or "deferred"
+// if the last attempt of any recipient is such, "sent" if all of them
+// are delivered and "queued" if there were no attempts yet
+func (m *TrackedMessage) status() string {
+	if len(m.Attempts) == 0 {
+		return "queued"
+	}
+	last := make(map[string]string, len(m.Recipients))
+	for _, a := range m.Attempts {
+		last[a.Recipient] = a.Status
+	}
+	res := "sent"
+	for _, st := range last {
+		if st == "bounced" {
+			return st
+		} else if st == "deferred" {
+			res = st
+		}
+	}
+	return res
+}
+
+func (q *messageQuery) match(m *TrackedMessage) bool {
+	if m.FirstSeen.Before(q.since) {
+		return false
+	}
+	if q.from != "" && !strings.Contains(strings.ToLower(m.Sender), q.from) {
+		return false
+	}
+	if q.client != "" && !strings.Contains(strings.ToLower(m.Client), q.client) {
+		return false
+	}
+	if q.status != "" && q.status != m.status() {
+		return false
+	}
+	if q.to != "" {
+		for _, r := range m.Recipients {
+			if strings.Contains(strings.ToLower(r), q.to) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// search returns a page of matching messages, the newest first, and
+// the total number of matches
+func (t *messageTracker) search(q *messageQuery) ([]MessageSummary, int) {
+	t.Lock()
+	defer t.Unlock()
+	res := []MessageSummary{}
+	total := 0
+	for e := t.order.Back(); e != nil; e = e.Prev() {
+		m := e.Value.(*TrackedMessage)
+		if !q.match(m) {
+			continue
+		}
+		total++
+		if total <= q.offset || len(res) >= q.limit {
+			continue
+		}
+		res = append(res, MessageSummary{
+			QueueID:    m.QueueID,
+			FirstSeen:  m.FirstSeen,
+			Client:     m.Client,
+			Sender:     m.Sender,
+			Recipients: append([]string{}, m.Recipients...),
+			Size:       m.Size,
+			Status:     m.status(),
+			Removed:    m.Removed,
+		})
+	}
+	return res, total
+}
+
+// messagesCmd is the socket "messages [from=...] [to=...] ..." command
+func messagesCmd(args []string) string {
+	if tracker == nil {
+		return "Message tracking is disabled\n"
+	}
+	v := url.Values{}
+	for _, a := range args {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Sprintf("Incorrect parameter %q, should be NAME=VALUE\n", a)
+		}
+		v.Set(kv[0], kv[1])
+	}
+	q, err := newMessageQuery(v, time.Now())
+	if err != nil {
+		return err.Error() + "\n"
+	}
+
+	found, total := tracker.search(q)
+	var res string
+	for _, m := range found {
+		res += fmt.Sprintf("%s %-12s %-8s <%s> -> <%s> %s\n", m.FirstSeen.Format("2006-01-02 15:04:05"),
+			m.QueueID, m.Status, m.Sender, strings.Join(m.Recipients, ">, <"), m.Client)
+	}
+	res += fmt.Sprintf("%d of %d messages\n", len(found), total)
+	return res
+}
+
+// handleMessages обрабатывает запрос /messages
+func handleMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if tracker == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Message tracking is disabled"})
+		return
+	}
+
+	q, err := newMessageQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	found, total := tracker.search(q)
+	json.NewEncoder(w).Encode(MessagesResponse{
+		Total:    total,
+		Offset:   q.offset,
+		Limit:    q.limit,
+		Messages: found,
+	})
+}
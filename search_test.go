@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func searchTestTracker() {
+	PostfixParserInit(&Config{cmd: "file"})
+	tracker = newMessageTracker(100, 1000000*time.Hour)
+	lines := append(trackerTestLog,
+		"Apr  1 11:00:00 mail postfix/smtpd[1000]: 0B1C2D3E4F: client=unknown[10.1.1.1]",
+		"Apr  1 11:00:01 mail postfix/qmgr[1002]: 0B1C2D3E4F: from=<Alice@Example.com>, size=100, nrcpt=1 (queue active)",
+		"Apr  1 11:00:02 mail postfix/smtp[1003]: 0B1C2D3E4F: to=<dave@example.net>, relay=mx.example.net[1.2.3.4]:25, delay=1, dsn=5.1.1, status=bounced (user unknown)",
+		"Apr  1 11:05:00 mail postfix/pickup[1005]: 1A2B3C4D5E: uid=0 from=<root>",
+	)
+	for _, l := range lines {
+		PostfixLineParse(l)
+	}
+}
+
+func TestMessageSearch(t *testing.T) {
+	searchTestTracker()
+	defer func() { tracker = nil }()
+
+	tests := []struct {
+		query string
+		ids   []string
+	}{
+		{"", []string{"1A2B3C4D5E", "0B1C2D3E4F", "AD59432D65"}},
+		{"from=alice@", []string{"0B1C2D3E4F", "AD59432D65"}},
+		{"from=alice@&to=carol", []string{"AD59432D65"}},
+		{"client=10.1.1.", []string{"0B1C2D3E4F"}},
+		{"status=bounced", []string{"0B1C2D3E4F"}},
+		{"status=queued", []string{"1A2B3C4D5E"}},
+		{"limit=1&offset=1", []string{"0B1C2D3E4F"}},
+	}
+	for _, tt := range tests {
+		v, _ := url.ParseQuery(tt.query)
+		q, err := newMessageQuery(v, time.Now())
+		if err != nil {
+			t.Fatalf("query %q error: %v", tt.query, err)
+		}
+		found, _ := tracker.search(q)
+		var ids []string
+		for _, m := range found {
+			ids = append(ids, m.QueueID)
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+			t.Errorf("query %q found %v, want %v", tt.query, ids, tt.ids)
+		}
+	}
+
+	if _, err := newMessageQuery(url.Values{"subject": {"x"}}, time.Now()); err == nil {
+		t.Error("Expected error for unknown parameter")
+	}
+}
+
+func TestMessagesCmd(t *testing.T) {
+	searchTestTracker()
+	defer func() { tracker = nil }()
+
+	resp := messagesCmd([]string{"from=alice@", "status=bounced"})
+	if !strings.Contains(resp, "0B1C2D3E4F   bounced  <Alice@Example.com> -> <dave@example.net>") ||
+		!strings.HasSuffix(resp, "1 of 1 messages\n") {
+		t.Errorf("unexpected messages output:\n%s", resp)
+	}
+	if resp := messagesCmd([]string{"alice"}); !strings.HasPrefix(resp, "Incorrect parameter") {
+		t.Errorf("Expected an error, got %q", resp)
+	}
+}
+
+func TestHandleMessages(t *testing.T) {
+	searchTestTracker()
+	defer func() { tracker = nil }()
+
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest("GET", "/messages?to=example.net&limit=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var response MessagesResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 2 || len(response.Messages) != 1 || response.Messages[0].QueueID != "0B1C2D3E4F" {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	rr = httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest("GET", "/messages?limit=0", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
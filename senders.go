@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	senderWindowBuckets = 60    // resolution of sliding windows
+	sendersMaxKeys      = 10000 // identities tracked at once
+	sendersMaxPending   = 100000
+	sendersPendingAge   = time.Hour // received messages never becoming active are forgotten
+)
+
+var senderKinds = []string{"sender", "sasl"}
+
+// SenderLimit is a sending limit within the window, 0 means no limit
+type SenderLimit struct {
+	Messages   uint64 `json:"messages"`
+	Recipients uint64 `json:"recipients"`
+}
+
+// SendersConfig is the "senders" section of the config file: the
+// sliding window, default limits, limits of particular senders or SASL
+// usernames and actions to run when a limit is exceeded
+type SendersConfig struct {
+	Window          jsonDuration           `json:"window"`
+	SenderLimit                            // default limits
+	Unauthenticated bool                   `json:"unauthenticated"` // count smtpd mail without SASL login too
+	Identities      map[string]SenderLimit `json:"identities,omitempty"`
+	Actions         []*AlertAction         `json:"actions,omitempty"`
+}
+
+// SenderStats is an identity in /top/senders response
+type SenderStats struct {
+	Identity   string `json:"identity"`
+	Kind       string `json:"kind"`
+	Messages   uint64 `json:"messages"`
+	Recipients uint64 `json:"recipients"`
+	OverLimit  bool   `json:"over_limit"`
+}
+
+// SendersResponse структура для JSON-ответа /top/senders
+type SendersResponse struct {
+	Window  string        `json:"window"`
+	By      string        `json:"by"`
+	Senders []SenderStats `json:"senders"`
+}
+
+type windowBucket struct {
+	slot       int64
+	messages   uint64
+	recipients uint64
+}
+
+// windowCounter counts messages and recipients per key in a sliding
+// window split into buckets
+type windowCounter struct {
+	step time.Duration
+	keys map[string]*[senderWindowBuckets]windowBucket
+}
+
+func newWindowCounter(window time.Duration) *windowCounter {
+	step := window / senderWindowBuckets
+	if step <= 0 {
+		step = time.Second
+	}
+	return &windowCounter{step: step, keys: make(map[string]*[senderWindowBuckets]windowBucket)}
+}
+
+func (w *windowCounter) add(key string, ts time.Time, rcpts uint64) {
+	buckets, ok := w.keys[key]
+	if !ok {
+		if len(w.keys) >= sendersMaxKeys {
+			w.expire(ts)
+			if len(w.keys) >= sendersMaxKeys {
+				return
+			}
+		}
+		buckets = new([senderWindowBuckets]windowBucket)
+		w.keys[key] = buckets
+	}
+	slot := ts.UnixNano() / int64(w.step)
+	b := &buckets[slot%senderWindowBuckets]
+	if b.slot != slot {
+		*b = windowBucket{slot: slot}
+	}
+	b.messages++
+	b.recipients += rcpts
+}
+
+func (w *windowCounter) sum(key string, now time.Time) (uint64, uint64) {
+	buckets, ok := w.keys[key]
+	if !ok {
+		return 0, 0
+	}
+	var msgs, rcpts uint64
+	slot := now.UnixNano() / int64(w.step)
+	for _, b := range buckets {
+		if b.slot > slot-senderWindowBuckets && b.slot <= slot {
+			msgs += b.messages
+			rcpts += b.recipients
+		}
+	}
+	return msgs, rcpts
+}
+
+// expire forgets keys without events in the window
+func (w *windowCounter) expire(now time.Time) {
+	for key := range w.keys {
+		if msgs, _ := w.sum(key, now); msgs == 0 {
+			delete(w.keys, key)
+		}
+	}
+}
+
+// senderWatcher tracks outbound volume per envelope sender and per SASL
+// username to find compromised accounts
+type senderWatcher struct {
+	sync.Mutex
+	cfg        SendersConfig
+	counters   map[string]*windowCounter // by kind
+	pending    map[string]pendingMessage // by received queue ID
+	overLimit  map[string]bool           // kind + ":" + identity
+	lastExpire time.Time
+}
+
+// pendingMessage is a received message waiting for qmgr
+type pendingMessage struct {
+	username string // SASL
+	received time.Time
+}
+
+var senderWatch *senderWatcher // nil in file mode
+
+func newSenderWatcher(cfg SendersConfig) *senderWatcher {
+	if cfg.Window == 0 {
+		cfg.Window = jsonDuration(time.Hour)
+	}
+	w := &senderWatcher{
+		cfg:       cfg,
+		counters:  make(map[string]*windowCounter),
+		pending:   make(map[string]pendingMessage),
+		overLimit: make(map[string]bool),
+	}
+	for _, kind := range senderKinds {
+		w.counters[kind] = newWindowCounter(time.Duration(cfg.Window))
+	}
+	return w
+}
+
+// received remembers a new message from a line "QID: client=..., sasl_username=alice"
+// or "QID: uid=1000 from=<alice>", it is counted once it becomes active.
+// Only submissions are tracked: pickup and smtpd with SASL login, or
+// any smtpd mail if unauthenticated is set. s is the rest after "postfix/".
+func (w *senderWatcher) received(queueID, s string) {
+	if w == nil {
+		return
+	}
+	username := logField(s, "sasl_username")
+	if username == "" && !strings.HasPrefix(s, "pickup[") && !w.cfg.Unauthenticated {
+		return
+	}
+	now := time.Now()
+	w.Lock()
+	defer w.Unlock()
+	if now.Sub(w.lastExpire) >= sendersPendingAge/4 {
+		w.lastExpire = now
+		for id, m := range w.pending {
+			if now.Sub(m.received) > sendersPendingAge {
+				delete(w.pending, id)
+			}
+		}
+	}
+	if len(w.pending) >= sendersMaxPending { // lost qmgr lines, start over
+		w.pending = make(map[string]pendingMessage)
+	}
+	w.pending[queueID] = pendingMessage{username: username, received: now}
+}
+
+// cleanupRejected forgets a message rejected or discarded by cleanup
+// "cleanup[123]: QID: milter-reject: END-OF-MESSAGE from ...", it never
+// becomes active. s is the rest after "postfix/".
+func (w *senderWatcher) cleanupRejected(s string) {
+	if w == nil {
+		return
+	}
+	daemon, rest, ok := splitDaemon(s)
+	if !ok || daemon != "cleanup" {
+		return
+	}
+	queueID, rest, ok := cutQueueID(rest)
+	if !ok {
+		return
+	}
+	for _, action := range []string{"reject: ", "milter-reject: ", "discard: ", "milter-discard: "} {
+		if strings.HasPrefix(rest, action) {
+			w.removed(queueID)
+			return
+		}
+	}
+}
+
+func (w *senderWatcher) removed(queueID string) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	delete(w.pending, queueID)
+	w.Unlock()
+}
+
+// active counts a new message from a qmgr line
+// "QID: from=<alice@example.com>, size=2048, nrcpt=2 (queue active)",
+// messages coming back from the deferred queue are not counted again
+func (w *senderWatcher) active(queueID, prefix, s string) {
+	if w == nil {
+		return
+	}
+	now := time.Now()
+	ts, ok := logLineTime(prefix, now)
+	if !ok {
+		ts = now
+	}
+	sender := strings.ToLower(logField(s, "from"))
+	if sender == "" {
+		sender = "<>"
+	}
+	nrcpt, _ := strconv.ParseUint(logField(s, "nrcpt"), 10, 64)
+
+	w.Lock()
+	m, ok := w.pending[queueID]
+	if !ok {
+		w.Unlock()
+		return
+	}
+	delete(w.pending, queueID)
+	username := m.username
+	var exceeded []SenderStats
+	for _, kind := range senderKinds {
+		id := sender
+		if kind == "sasl" {
+			if id = username; id == "" {
+				continue
+			}
+		}
+		w.counters[kind].add(id, ts, nrcpt)
+		if st := w.check(kind, id, now); st != nil {
+			exceeded = append(exceeded, *st)
+		}
+	}
+	w.Unlock()
+
+	for _, st := range exceeded {
+		w.notify(st, now)
+	}
+}
+
+func (w *senderWatcher) limitFor(id string) SenderLimit {
+	if l, ok := w.cfg.Identities[id]; ok {
+		return l
+	}
+	return w.cfg.SenderLimit
+}
+
+func (w *senderWatcher) stats(kind, id string, now time.Time) SenderStats {
+	msgs, rcpts := w.counters[kind].sum(id, now)
+	l := w.limitFor(id)
+	return SenderStats{
+		Identity:   id,
+		Kind:       kind,
+		Messages:   msgs,
+		Recipients: rcpts,
+		OverLimit: l.Messages > 0 && msgs > l.Messages ||
+			l.Recipients > 0 && rcpts > l.Recipients,
+	}
+}
+
+// check updates the over limit state of an identity and returns its
+// stats if it has just exceeded the limit, the caller should hold the lock
+func (w *senderWatcher) check(kind, id string, now time.Time) *SenderStats {
+	st := w.stats(kind, id, now)
+	key := kind + ":" + id
+	was := w.overLimit[key]
+	if st.OverLimit {
+		w.overLimit[key] = true
+	} else {
+		delete(w.overLimit, key)
+	}
+	if st.OverLimit && !was {
+		return &st
+	}
+	return nil
+}
+
+// notify reports an exceeded limit to the log, /events clients and
+// the configured actions
+func (w *senderWatcher) notify(st SenderStats, now time.Time) {
+	l := w.limitFor(st.Identity)
+	fmt.Printf("%s %s exceeded the limit: %d messages, %d recipients within %s\n",
+		st.Kind, st.Identity, st.Messages, st.Recipients, time.Duration(w.cfg.Window))
+	events.publish(PostfixEvent{Type: "sender-limit", Identity: st.Identity})
+
+	p := AlertPayload{Alert: "sender-limit:" + st.Kind + ":" + st.Identity, State: alertFiring,
+		Value: float64(st.Messages), Op: ">", Threshold: float64(l.Messages), Since: now, Time: now}
+	if l.Messages == 0 || st.Messages <= l.Messages {
+		p.Value, p.Threshold = float64(st.Recipients), float64(l.Recipients)
+	}
+	p.Host, _ = os.Hostname()
+	for _, a := range w.cfg.Actions {
+		go a.run(p)
+	}
+}
+
+// top returns n identities of a kind with the most messages or
+// recipients within the window, optionally only those over the limit
+func (w *senderWatcher) top(kind, by string, n int, overOnly bool, now time.Time) []SenderStats {
+	w.Lock()
+	defer w.Unlock()
+	c := w.counters[kind]
+	c.expire(now)
+	res := make([]SenderStats, 0, len(c.keys))
+	for id := range c.keys {
+		if st := w.stats(kind, id, now); st.OverLimit || !overOnly {
+			res = append(res, st)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i].Messages, res[j].Messages
+		if by == "recipients" {
+			a, b = res[i].Recipients, res[j].Recipients
+		}
+		return a > b || a == b && res[i].Identity < res[j].Identity
+	})
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// handleTopSenders обрабатывает запрос /top/senders
+func handleTopSenders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if senderWatch == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Senders tracking is disabled"})
+		return
+	}
+
+	q := r.URL.Query()
+	kind, by := q.Get("kind"), q.Get("by")
+	if kind == "" {
+		kind = "sender"
+	}
+	if by == "" {
+		by = "messages"
+	}
+	if !strArrayLookup(senderKinds, kind) || by != "messages" && by != "recipients" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error: "Parameter kind can be sender or sasl, by can be messages or recipients",
+		})
+		return
+	}
+	n := topDefaultN
+	if v, err := strconv.Atoi(q.Get("n")); err == nil && v > 0 {
+		n = v
+	}
+
+	json.NewEncoder(w).Encode(SendersResponse{
+		Window:  time.Duration(senderWatch.cfg.Window).String(),
+		By:      by,
+		Senders: senderWatch.top(kind, by, n, q.Get("over_limit") == "1", time.Now()),
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSenderLimits(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	senderWatch = newSenderWatcher(SendersConfig{
+		SenderLimit: SenderLimit{Messages: 3},
+		Identities:  map[string]SenderLimit{"bulk@example.com": {Recipients: 100}},
+	})
+	defer func() { senderWatch = nil }()
+
+	ts := time.Now().Format(syslogTimeLayout)
+	send := func(qid, user, from string, nrcpt int) {
+		received := "smtpd[100]: " + qid + ": client=unknown[192.0.2.1], sasl_method=PLAIN, sasl_username=" + user
+		if user == "" {
+			received = "pickup[100]: " + qid + ": uid=1000 from=<" + from + ">"
+		}
+		PostfixLineParse(ts + " mail postfix/" + received)
+		line := fmt.Sprintf("%s mail postfix/qmgr[101]: %s: from=<%s>, size=1024, nrcpt=%d (queue active)", ts, qid, from, nrcpt)
+		PostfixLineParse(line)
+		// a deferred message coming back to the active queue is not counted again
+		PostfixLineParse(line)
+	}
+	for i := 0; i < 4; i++ {
+		send(fmt.Sprintf("A%d", i), "alice", "Alice@example.com", 10)
+	}
+	send("B1", "", "bulk@example.com", 60)
+	send("B2", "", "bulk@example.com", 60)
+
+	senders := senderWatch.top("sender", "messages", 10, false, time.Now())
+	if len(senders) != 2 || senders[0].Identity != "alice@example.com" || senders[0].Messages != 4 ||
+		senders[0].Recipients != 40 || !senders[0].OverLimit {
+		t.Errorf("unexpected senders %+v", senders)
+	}
+	if senders[1].Identity != "bulk@example.com" || senders[1].Messages != 2 || !senders[1].OverLimit {
+		t.Errorf("unexpected bulk sender %+v", senders[1])
+	}
+	if users := senderWatch.top("sasl", "messages", 10, false, time.Now()); len(users) != 1 ||
+		users[0].Identity != "alice" || users[0].Messages != 4 {
+		t.Errorf("unexpected SASL users %+v", users)
+	}
+	if len(senderWatch.pending) != 0 {
+		t.Errorf("Expected no pending messages, got %d", len(senderWatch.pending))
+	}
+
+	rr := httptest.NewRecorder()
+	handleTopSenders(rr, httptest.NewRequest("GET", "/top/senders?kind=sasl&by=recipients&over_limit=1", nil))
+	var response SendersResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Window != "1h0m0s" || len(response.Senders) != 1 || response.Senders[0].Recipients != 40 {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	rr = httptest.NewRecorder()
+	handleTopSenders(rr, httptest.NewRequest("GET", "/top/senders?kind=recipient", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSendersSubmissionsOnly(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	defer func() { senderWatch = nil }()
+
+	ts := time.Now().Format(syslogTimeLayout) + " mail postfix/"
+	lines := []string{
+		// inbound mail from the internet
+		"smtpd[100]: 0A2D132D5F: client=mail.example.net[192.0.2.1]",
+		"qmgr[101]: 0A2D132D5F: from=<forged@example.com>, size=1024, nrcpt=1 (queue active)",
+		// rejected by a milter after the queue ID is assigned
+		"smtpd[100]: 1B2D132D5F: client=unknown[192.0.2.2], sasl_method=PLAIN, sasl_username=alice",
+		"cleanup[102]: 1B2D132D5F: milter-reject: END-OF-MESSAGE from unknown[192.0.2.2]: 5.7.1 Spam; from=<alice@example.com> to=<x@example.org> proto=ESMTP helo=<pc>",
+		"pickup[103]: 2C2D132D5F: uid=1000 from=<bob@example.com>",
+		"cleanup[102]: 2C2D132D5F: discard: header Subject: test from local; from=<bob@example.com> to=<x@example.org>: discarded",
+	}
+	for _, unauthenticated := range []bool{false, true} {
+		senderWatch = newSenderWatcher(SendersConfig{Unauthenticated: unauthenticated})
+		for _, l := range lines {
+			PostfixLineParse(ts + l)
+		}
+		senders := senderWatch.top("sender", "messages", 10, false, time.Now())
+		if unauthenticated && (len(senders) != 1 || senders[0].Identity != "forged@example.com") ||
+			!unauthenticated && len(senders) != 0 {
+			t.Errorf("unauthenticated=%v: unexpected senders %+v", unauthenticated, senders)
+		}
+		if len(senderWatch.pending) != 0 {
+			t.Errorf("unauthenticated=%v: rejected messages are pending %+v", unauthenticated, senderWatch.pending)
+		}
+	}
+}
+
+func TestWindowCounter(t *testing.T) {
+	w := newWindowCounter(time.Minute)
+	now := time.Unix(1700000000, 0)
+	w.add("a", now.Add(-2*time.Minute), 5)
+	w.add("a", now.Add(-30*time.Second), 2)
+	w.add("a", now, 1)
+	if msgs, rcpts := w.sum("a", now); msgs != 2 || rcpts != 3 {
+		t.Errorf("sum = %d, %d; want 2, 3", msgs, rcpts)
+	}
+	w.expire(now.Add(2 * time.Minute))
+	if len(w.keys) != 0 {
+		t.Errorf("Expected expired keys to be removed, got %d", len(w.keys))
+	}
+}
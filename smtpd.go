@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+var smtpdCounters = counterGroup{
+	name: "smtpd",
+	names: []string{"smtpd-connect", "smtpd-disconnect", "smtpd-lost-connection",
+		"smtpd-timeout", "smtpd-too-many-errors"},
+	gauges: []string{"smtpd-sessions"},
+	labeled: []labeledCounter{
+		{name: "smtpd-lost-connection-after", label: "stage"},
+		{name: "smtpd-timeout-after", label: "stage"},
+		{name: "smtpd-too-many-errors-after", label: "stage"},
+		{name: "smtpd-command", label: "command"},
+		{name: "smtpd-command-failed", label: "command"},
+	},
+}
+
+// smtpdAbnormalEnds maps the beginning of abnormal session termination
+// messages to counters, the SMTP stage follows the prefix
+var smtpdAbnormalEnds = []struct {
+	prefix  string
+	counter string
+}{
+	{"lost connection after ", "smtpd-lost-connection"},
+	{"timeout after ", "smtpd-timeout"},
+	{"too many errors after ", "smtpd-too-many-errors"},
+}
+
+// smtpdLineParse counts smtpd connection and session lines. It returns
+// false if the line should be classified further.
+func smtpdLineParse(s string) bool {
+	daemon, msg, ok := postfixDaemon(s)
+	if !ok || daemon != "smtpd" {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(msg, "connect from "):
+		msgStatusCounters.lock()
+		msgStatusCounters.inc("smtpd-connect", 1)
+		msgStatusCounters.gauges["smtpd-sessions"]++
+		msgStatusCounters.unlock()
+		topClients.add("connections", clientIP(strings.TrimPrefix(msg, "connect from ")))
+	case strings.HasPrefix(msg, "disconnect from "):
+		msgStatusCounters.lock()
+		msgStatusCounters.inc("smtpd-disconnect", 1)
+		if msgStatusCounters.gauges["smtpd-sessions"] > 0 { // not a session started before us
+			msgStatusCounters.gauges["smtpd-sessions"]--
+		}
+		smtpdCommandStats(msg)
+		msgStatusCounters.unlock()
+	default:
+		for _, ab := range smtpdAbnormalEnds {
+			if !strings.HasPrefix(msg, ab.prefix) {
+				continue
+			}
+			stage := msg[len(ab.prefix):]
+			if i := strings.Index(stage, " from "); i >= 0 {
+				stage = stage[:i]
+			}
+			msgStatusCounters.lock()
+			msgStatusCounters.inc(ab.counter, 1)
+			msgStatusCounters.incLabel(ab.counter+"-after", stage, 1)
+			msgStatusCounters.unlock()
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// smtpdCommandStats counts commands from the disconnect summary:
+// "disconnect from host[1.2.3.4] ehlo=1 mail=1 rcpt=0/1 data=0/1 quit=1 commands=3/5",
+// where "0/1" means 0 successful of 1. The caller should hold the lock.
+func smtpdCommandStats(msg string) {
+	fields := strings.Fields(msg)
+	if len(fields) < 3 {
+		return
+	}
+	for _, f := range fields[3:] {
+		cmd, v, ok := strings.Cut(f, "=")
+		if !ok || cmd == "commands" {
+			continue
+		}
+		okCnt, total, failed := v, v, false
+		if i := strings.IndexByte(v, '/'); i >= 0 {
+			okCnt, total, failed = v[:i], v[i+1:], true
+		}
+		t, err := strconv.ParseUint(total, 10, 64)
+		if err != nil {
+			continue
+		}
+		msgStatusCounters.incLabel("smtpd-command", cmd, t)
+		if failed {
+			if o, err := strconv.ParseUint(okCnt, 10, 64); err == nil && t > o {
+				msgStatusCounters.incLabel("smtpd-command-failed", cmd, t-o)
+			}
+		}
+	}
+}
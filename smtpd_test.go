@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSmtpdLineParse(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	lines := []string{
+		"Apr  1 10:00:00 mail postfix/smtpd[100]: connect from mail1.example.com[192.0.2.1]",
+		"Apr  1 10:00:00 mail postfix/submission/smtpd[101]: connect from unknown[192.0.2.2]",
+		"Apr  1 10:00:01 mail postfix/smtpd[102]: connect from unknown[192.0.2.3]",
+		"Apr  1 10:00:01 mail postfix/smtpd[100]: AD59432D65: client=mail1.example.com[192.0.2.1]",
+		"Apr  1 10:00:02 mail postfix/smtpd[100]: disconnect from mail1.example.com[192.0.2.1] ehlo=1 mail=1 rcpt=1 data=1 quit=1 commands=5",
+		"Apr  1 10:00:03 mail postfix/submission/smtpd[101]: lost connection after AUTH from unknown[192.0.2.2]",
+		"Apr  1 10:00:03 mail postfix/submission/smtpd[101]: disconnect from unknown[192.0.2.2] ehlo=1 auth=0/1 commands=1/2",
+		"Apr  1 10:00:04 mail postfix/smtpd[102]: NOQUEUE: reject: RCPT from unknown[192.0.2.3]: 554 5.7.1 <x@example.com>: Relay access denied; from=<a@b.c> to=<x@example.com> proto=ESMTP helo=<spam>",
+		"Apr  1 10:00:05 mail postfix/smtpd[102]: too many errors after RCPT from unknown[192.0.2.3]",
+		"Apr  1 10:00:06 mail postfix/smtpd[103]: timeout after END-OF-MESSAGE from unknown[192.0.2.4]",
+	}
+	for _, l := range lines {
+		PostfixLineParse(l)
+	}
+
+	want := map[string]uint64{
+		"smtpd-connect":         3,
+		"smtpd-disconnect":      2,
+		"smtpd-sessions":        1,
+		"smtpd-lost-connection": 1,
+		"smtpd-timeout":         1,
+		"smtpd-too-many-errors": 1,
+		"received":              1,
+		"rejected":              1,
+	}
+	for name, v := range want {
+		if got := msgStatusCounters.value(name); got != v {
+			t.Errorf("Expected %s=%d, got %d", name, v, got)
+		}
+	}
+
+	labeled := map[string]map[string]uint64{
+		"smtpd-lost-connection-after": {"AUTH": 1},
+		"smtpd-timeout-after":         {"END-OF-MESSAGE": 1},
+		"smtpd-too-many-errors-after": {"RCPT": 1},
+		"smtpd-command":               {"ehlo": 2, "mail": 1, "rcpt": 1, "data": 1, "quit": 1, "auth": 1},
+		"smtpd-command-failed":        {"auth": 1},
+	}
+	for name, values := range labeled {
+		for label, v := range values {
+			if got := msgStatusCounters.labeled[name][label]; got != v {
+				t.Errorf("Expected %s[%s]=%d, got %d", name, label, v, got)
+			}
+		}
+		if len(msgStatusCounters.labeled[name]) != len(values) {
+			t.Errorf("unexpected %s values %v", name, msgStatusCounters.labeled[name])
+		}
+	}
+
+	// sessions are not affected by reset
+	msgStatusCounters.reset()
+	if !strings.Contains(PostfixStats(), "smtpd-sessions          1\n") {
+		t.Errorf("unexpected stats output:\n%s", PostfixStats())
+	}
+	if !strings.Contains(metricsString(), "# TYPE mlogtail_smtpd_sessions gauge\nmlogtail_smtpd_sessions 1\n") {
+		t.Errorf("unexpected metrics output:\n%s", metricsString())
+	}
+}
+
+func TestSmtpdSessionsOrphanDisconnect(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	lines := []string{
+		// sessions opened before the start
+		"Apr  1 10:00:00 mail postfix/smtpd[90]: disconnect from old1.example.com[192.0.2.10] ehlo=1 quit=1 commands=2",
+		"Apr  1 10:00:00 mail postfix/smtpd[91]: disconnect from old2.example.com[192.0.2.11] ehlo=1 quit=1 commands=2",
+		"Apr  1 10:00:01 mail postfix/smtpd[100]: connect from mail1.example.com[192.0.2.1]",
+		"Apr  1 10:00:01 mail postfix/smtpd[101]: connect from mail2.example.com[192.0.2.2]",
+	}
+	for _, l := range lines {
+		PostfixLineParse(l)
+	}
+	if got := msgStatusCounters.value("smtpd-sessions"); got != 2 {
+		t.Errorf("Expected smtpd-sessions=2, got %d", got)
+	}
+	PostfixLineParse("Apr  1 10:00:02 mail postfix/smtpd[100]: disconnect from mail1.example.com[192.0.2.1] ehlo=1 quit=1 commands=2")
+	if got := msgStatusCounters.value("smtpd-sessions"); got != 1 {
+		t.Errorf("Expected smtpd-sessions=1, got %d", got)
+	}
+}
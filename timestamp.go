@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	syslogTimeLayout = "Jan _2 15:04:05"
+
+	// lines up to this much later than -until may still be followed by
+	// lines within the range, syslog does not keep the order strictly
+	timeFilterSlack = time.Minute
+)
+
+// logLineTime returns the time of a log line in the classic syslog format
+// ("Jul 22 19:06:42 ...") or in RFC3339 ("2025-07-22T19:06:42.123+03:00 ...").
+// The year is not logged in the classic format, so it is taken from
+// now, a time in the future means the line was logged last year.
+func logLineTime(s string, now time.Time) (time.Time, bool) {
+	if len(s) > 0 && s[0] >= '0' && s[0] <= '9' {
+		return isoLineTime(s, now)
+	}
+	if len(s) < len(syslogTimeLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(syslogTimeLayout, s[:len(syslogTimeLayout)], now.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
+
+func isoLineTime(s string, now time.Time) (time.Time, bool) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+	// without a time zone or with "+0300" one
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999Z0700", "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// timeFilter selects lines logged within [since, until) in file mode,
+// zero since or until means no limit
+type timeFilter struct {
+	since, until time.Time
+	now          time.Time
+}
+
+// check returns false if a line is out of the range, and stop is true
+// once the lines are surely past until in a chronologically sorted file
+func (f *timeFilter) check(line string) (ok, stop bool) {
+	if f == nil {
+		return true, false
+	}
+	ts, tsOk := logLineTime(line, f.now)
+	if !tsOk {
+		return false, false
+	}
+	if !f.since.IsZero() && ts.Before(f.since) {
+		return false, false
+	}
+	if !f.until.IsZero() && !ts.Before(f.until) {
+		return false, ts.Sub(f.until) > timeFilterSlack
+	}
+	return true, false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogLineTime(t *testing.T) {
+	now := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"Jan  2 09:00:00 mail postfix/smtpd[1]: connect":             time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC),
+		"Dec 31 23:59:59 mail postfix/smtpd[1]: connect":             time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+		"2024-12-31T23:00:00.5+02:00 mail postfix/smtpd[1]: connect": time.Date(2024, 12, 31, 21, 0, 0, 5e8, time.UTC),
+		"2024-12-31T23:00:00+0200 mail postfix/smtpd[1]: connect":    time.Date(2024, 12, 31, 21, 0, 0, 0, time.UTC),
+		"2024-12-31T23:00:00 mail postfix/smtpd[1]: connect":         time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC),
+	}
+	for s, want := range tests {
+		if got, ok := logLineTime(s, now); !ok || !got.Equal(want) {
+			t.Errorf("logLineTime(%q) = %v, %v; want %v", s, got, ok, want)
+		}
+	}
+	if _, ok := logLineTime("2024-12-31 mail postfix", now); ok {
+		t.Error("Expected no time for an incorrect timestamp")
+	}
+}
+
+func TestTimeFilter(t *testing.T) {
+	now := time.Date(2025, 4, 2, 0, 0, 0, 0, time.UTC)
+	f := &timeFilter{
+		since: time.Date(2025, 4, 1, 10, 0, 0, 0, time.UTC),
+		until: time.Date(2025, 4, 1, 11, 0, 0, 0, time.UTC),
+		now:   now,
+	}
+	tests := []struct {
+		line     string
+		ok, stop bool
+	}{
+		{"Apr  1 09:59:59 mail postfix/smtpd[1]: connect", false, false},
+		{"Apr  1 10:00:00 mail postfix/smtpd[1]: connect", true, false},
+		{"2025-04-01T10:30:00Z mail postfix/smtpd[1]: connect", true, false},
+		{"Apr  1 11:00:00 mail postfix/smtpd[1]: connect", false, false},
+		{"Apr  1 11:05:00 mail postfix/smtpd[1]: connect", false, true},
+		{"garbage", false, false},
+	}
+	for _, tt := range tests {
+		if ok, stop := f.check(tt.line); ok != tt.ok || stop != tt.stop {
+			t.Errorf("check(%q) = %v, %v; want %v, %v", tt.line, ok, stop, tt.ok, tt.stop)
+		}
+	}
+	var none *timeFilter
+	if ok, _ := none.check("garbage"); !ok {
+		t.Error("Expected a nil filter to pass everything")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+)
+
+var tlsCounters = counterGroup{
+	name: "tls",
+	names: []string{"tls-inbound", "tls-outbound",
+		"tls-inbound-failed", "tls-outbound-failed"},
+	labeled: []labeledCounter{
+		{name: "tls-inbound-trust", label: "trust"},
+		{name: "tls-outbound-trust", label: "trust"},
+		{name: "tls-inbound-protocol", label: "protocol"},
+		{name: "tls-outbound-protocol", label: "protocol"},
+		{name: "tls-inbound-cipher", label: "cipher"},
+		{name: "tls-outbound-cipher", label: "cipher"},
+	},
+}
+
+// tlsLineParse counts TLS sessions of smtpd (inbound) and smtp or lmtp
+// (outbound):
+// "Anonymous TLS connection established from host[1.2.3.4]: TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits) ..."
+// "Verified TLS connection established to mx.example.com[1.2.3.4]:25: TLSv1.2 with cipher ECDHE-RSA-AES256-GCM-SHA384 (256/256 bits)"
+// and handshake failures ("SSL_accept error from ...", "SSL_connect error to ...").
+// It returns false if the line should be classified further.
+func tlsLineParse(s string) bool {
+	daemon, msg, ok := postfixDaemon(s)
+	if !ok {
+		return false
+	}
+	var dir string
+	switch daemon {
+	case "smtpd":
+		dir = "tls-inbound"
+	case "smtp", "lmtp":
+		dir = "tls-outbound"
+	default:
+		return false
+	}
+
+	if strings.HasPrefix(msg, "SSL_accept error ") || strings.HasPrefix(msg, "SSL_connect error ") {
+		msgStatusCounters.lock()
+		msgStatusCounters.inc(dir+"-failed", 1)
+		msgStatusCounters.unlock()
+		return true
+	}
+
+	i := strings.Index(msg, " TLS connection established ")
+	if i < 0 {
+		return false
+	}
+	trust := strings.ToLower(msg[:i])
+	var proto, cipher string
+	if j := strings.LastIndex(msg, ": "); j > i {
+		f := strings.Fields(msg[j+2:])
+		if len(f) > 0 {
+			proto = f[0]
+		}
+		if len(f) > 3 && f[1] == "with" && f[2] == "cipher" {
+			cipher = f[3]
+		}
+	}
+
+	msgStatusCounters.lock()
+	msgStatusCounters.inc(dir, 1)
+	msgStatusCounters.incLabel(dir+"-trust", trust, 1)
+	if proto != "" {
+		msgStatusCounters.incLabel(dir+"-protocol", proto, 1)
+	}
+	if cipher != "" {
+		msgStatusCounters.incLabel(dir+"-cipher", cipher, 1)
+	}
+	msgStatusCounters.unlock()
+	return true
+}
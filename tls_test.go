@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTLSLineParse(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	lines := []string{
+		"Apr  1 10:00:00 mail postfix/smtpd[100]: Anonymous TLS connection established from mail1.example.com[192.0.2.1]: TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits) key-exchange X25519 server-signature RSA-PSS (2048 bits) server-digest SHA256",
+		"Apr  1 10:00:01 mail postfix/submission/smtpd[101]: Anonymous TLS connection established from unknown[192.0.2.2]: TLSv1.2 with cipher ECDHE-RSA-AES256-GCM-SHA384 (256/256 bits)",
+		"Apr  1 10:00:02 mail postfix/smtpd[102]: SSL_accept error from unknown[192.0.2.3]: lost connection",
+		"Apr  1 10:00:03 mail postfix/smtp[200]: Verified TLS connection established to mx.example.com[198.51.100.1]:25: TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits)",
+		"Apr  1 10:00:04 mail postfix/smtp[201]: Untrusted TLS connection established to mx.example.net[2001:db8::1]:25: TLSv1.2 with cipher ECDHE-RSA-AES128-GCM-SHA256 (128/128 bits)",
+		"Apr  1 10:00:05 mail postfix/smtp[202]: SSL_connect error to mx.example.org[198.51.100.2]:25: -1",
+	}
+	for _, l := range lines {
+		PostfixLineParse(l)
+	}
+
+	want := map[string]uint64{
+		"tls-inbound":         2,
+		"tls-outbound":        2,
+		"tls-inbound-failed":  1,
+		"tls-outbound-failed": 1,
+	}
+	for name, v := range want {
+		if got := msgStatusCounters.counters[name]; got != v {
+			t.Errorf("Expected %s=%d, got %d", name, v, got)
+		}
+	}
+
+	labeled := map[string]map[string]uint64{
+		"tls-inbound-trust":     {"anonymous": 2},
+		"tls-outbound-trust":    {"verified": 1, "untrusted": 1},
+		"tls-inbound-protocol":  {"TLSv1.3": 1, "TLSv1.2": 1},
+		"tls-outbound-protocol": {"TLSv1.3": 1, "TLSv1.2": 1},
+		"tls-inbound-cipher":    {"TLS_AES_256_GCM_SHA384": 1, "ECDHE-RSA-AES256-GCM-SHA384": 1},
+		"tls-outbound-cipher":   {"TLS_AES_256_GCM_SHA384": 1, "ECDHE-RSA-AES128-GCM-SHA256": 1},
+	}
+	for name, values := range labeled {
+		for label, v := range values {
+			if got := msgStatusCounters.labeled[name][label]; got != v {
+				t.Errorf("Expected %s[%s]=%d, got %d", name, label, v, got)
+			}
+		}
+	}
+
+	if !strings.Contains(metricsString(), `mlogtail_tls_outbound_protocol_total{protocol="TLSv1.3"} 1`) {
+		t.Errorf("unexpected metrics output:\n%s", metricsString())
+	}
+}
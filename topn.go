@@ -0,0 +1,235 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const topDefaultN = 20
+
+var (
+	// "reject: RCPT from unknown[1.2.3.4]: ..." or "reject: header ... from host[1.2.3.4];"
+	reRejectClient = regexp.MustCompile(`\bfrom [^\s\[]*\[([^\]]+)\]`)
+	topKinds       = []string{"clients", "networks"}
+	topBy          = []string{"rejected", "connections"}
+)
+
+// TopItem is a heavy hitter entry. Count may be overestimated by
+// up to Error.
+type TopItem struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+}
+
+// TopResponse структура для JSON-ответа /top/clients и /top/networks
+type TopResponse struct {
+	By    string    `json:"by"`
+	Items []TopItem `json:"items"`
+}
+
+// spaceSaving is the Space-Saving heavy hitters algorithm: it keeps at
+// most `capacity` keys, a new key replaces the least counted one and
+// takes its count.
+type spaceSaving struct {
+	capacity int
+	items    ssHeap
+	byKey    map[string]*ssItem
+}
+
+type ssItem struct {
+	TopItem
+	index int
+}
+
+// ssHeap is a min-heap of items by count
+type ssHeap []*ssItem
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *ssHeap) Push(x any) {
+	it := x.(*ssItem)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+func (h *ssHeap) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, byKey: make(map[string]*ssItem)}
+}
+
+func (s *spaceSaving) add(key string) {
+	if it, ok := s.byKey[key]; ok {
+		it.Count++
+		heap.Fix(&s.items, it.index)
+		return
+	}
+	if len(s.items) < s.capacity {
+		it := &ssItem{TopItem: TopItem{Key: key, Count: 1}}
+		heap.Push(&s.items, it)
+		s.byKey[key] = it
+		return
+	}
+	it := s.items[0] // the least counted key is replaced
+	delete(s.byKey, it.Key)
+	it.Key, it.Error = key, it.Count
+	it.Count++
+	s.byKey[key] = it
+	heap.Fix(&s.items, 0)
+}
+
+// top returns n most counted keys
+func (s *spaceSaving) top(n int) []TopItem {
+	res := make([]TopItem, 0, len(s.items))
+	for _, it := range s.items {
+		res = append(res, it.TopItem)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Count > res[j].Count || res[i].Count == res[j].Count && res[i].Key < res[j].Key
+	})
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// topTables keeps heavy hitter client IPs and networks by rejects and
+// connections. Unlike counters they are not reset.
+type topTables struct {
+	sync.Mutex
+	tables map[string]*spaceSaving // "clients-rejected", "networks-connections"...
+}
+
+var topClients *topTables // nil in file mode
+
+func newTopTables(capacity int) *topTables {
+	t := &topTables{tables: make(map[string]*spaceSaving)}
+	for _, kind := range topKinds {
+		for _, by := range topBy {
+			t.tables[kind+"-"+by] = newSpaceSaving(capacity)
+		}
+	}
+	return t
+}
+
+// clientNetwork returns /24 network of an IPv4 address and /64 of IPv6
+func clientNetwork(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	bits := 64
+	if addr.Is4() || addr.Is4In6() {
+		addr, bits = addr.Unmap(), 24
+	}
+	p, _ := addr.Prefix(bits)
+	return p.String()
+}
+
+// add counts a client IP event, by is "rejected" or "connections"
+func (t *topTables) add(by, ip string) {
+	if t == nil || ip == "" || ip == "unknown" {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.tables["clients-"+by].add(ip)
+	if network := clientNetwork(ip); network != "" {
+		t.tables["networks-"+by].add(network)
+	}
+}
+
+// addReject counts a client IP of a reject line
+func (t *topTables) addReject(s string) {
+	if t == nil {
+		return
+	}
+	if m := reRejectClient.FindStringSubmatch(s); m != nil {
+		t.add("rejected", m[1])
+	}
+}
+
+func (t *topTables) top(kind, by string, n int) ([]TopItem, error) {
+	if !strArrayLookup(topKinds, kind) {
+		return nil, fmt.Errorf("Unknown table %q, it can be one of %s", kind, strings.Join(topKinds, ", "))
+	}
+	if !strArrayLookup(topBy, by) {
+		return nil, fmt.Errorf("Unknown counter %q, it can be one of %s", by, strings.Join(topBy, ", "))
+	}
+	t.Lock()
+	defer t.Unlock()
+	return t.tables[kind+"-"+by].top(n), nil
+}
+
+// topCmd is the socket "top clients|networks [rejected|connections] [N]" command
+func topCmd(args []string) string {
+	if topClients == nil {
+		return "Top tables are disabled\n"
+	}
+	if len(args) == 0 {
+		return "Usage: top clients|networks [rejected|connections] [N]\n"
+	}
+	by, n := "rejected", topDefaultN
+	if len(args) > 1 {
+		by = args[1]
+	}
+	if len(args) > 2 {
+		if v, err := strconv.Atoi(args[2]); err == nil && v > 0 {
+			n = v
+		}
+	}
+	items, err := topClients.top(args[0], by, n)
+	if err != nil {
+		return err.Error() + "\n"
+	}
+	var res string
+	for _, it := range items {
+		res += fmt.Sprintf("%-40s%d\n", it.Key, it.Count)
+	}
+	return res
+}
+
+// handleTop обрабатывает запросы /top/clients и /top/networks
+func handleTop(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if topClients == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Top tables are disabled"})
+		return
+	}
+
+	q := r.URL.Query()
+	by := q.Get("by")
+	if by == "" {
+		by = "rejected"
+	}
+	n := topDefaultN
+	if v, err := strconv.Atoi(q.Get("n")); err == nil && v > 0 {
+		n = v
+	}
+	items, err := topClients.top(strings.TrimPrefix(r.URL.Path, "/top/"), by, n)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(TopResponse{By: by, Items: items})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := newSpaceSaving(3)
+	for _, k := range strings.Fields("a a a a b b b c c d a") {
+		s.add(k)
+	}
+	top := s.top(2)
+	if len(top) != 2 || top[0].Key != "a" || top[0].Count != 5 || top[0].Error != 0 {
+		t.Errorf("unexpected top %+v", top)
+	}
+	// "d" replaced "c" and took its count, "b" is exact and goes first
+	if top[1].Key != "b" || top[1].Count != 3 || top[1].Error != 0 {
+		t.Errorf("unexpected second item %+v", top[1])
+	}
+	if len(s.byKey) != 3 || len(s.items) != 3 {
+		t.Errorf("Expected 3 tracked keys, got %d", len(s.byKey))
+	}
+}
+
+func TestClientNetwork(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.66":         "192.0.2.0/24",
+		"::ffff:192.0.2.66":  "192.0.2.0/24",
+		"2001:db8:1:2:3::66": "2001:db8:1:2::/64",
+		"unknown":            "",
+	}
+	for ip, want := range tests {
+		if got := clientNetwork(ip); got != want {
+			t.Errorf("clientNetwork(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestTopClients(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	topClients = newTopTables(100)
+	defer func() { topClients = nil }()
+
+	for i := 1; i <= 5; i++ {
+		ip := "192.0.2." + strconv.Itoa(i)
+		for j := 0; j < i; j++ {
+			PostfixLineParse("Apr  1 10:00:00 mail postfix/smtpd[100]: connect from unknown[" + ip + "]")
+			PostfixLineParse("Apr  1 10:00:01 mail postfix/smtpd[100]: NOQUEUE: reject: RCPT from unknown[" + ip + "]: 554 5.7.1 <x@example.com>: Relay access denied; from=<a@b.c> to=<x@example.com> proto=ESMTP helo=<spam>")
+		}
+	}
+	PostfixLineParse("Apr  1 10:00:02 mail postfix/cleanup[101]: AD59432D65: reject: header Subject: spam from mail.example.net[198.51.100.7]; from=<a@example.net> to=<b@example.com> proto=ESMTP helo=<mail.example.net>: 5.7.1 Spam")
+
+	if resp := topCmd([]string{"clients", "rejected", "2"}); resp != "192.0.2.5"+strings.Repeat(" ", 31)+"5\n192.0.2.4"+strings.Repeat(" ", 31)+"4\n" {
+		t.Errorf("unexpected top output:\n%s", resp)
+	}
+	items, _ := topClients.top("networks", "connections", 10)
+	if len(items) != 1 || items[0].Key != "192.0.2.0/24" || items[0].Count != 15 {
+		t.Errorf("unexpected top networks %+v", items)
+	}
+
+	rr := httptest.NewRecorder()
+	handleTop(rr, httptest.NewRequest("GET", "/top/networks?by=rejected&n=5", nil))
+	var response TopResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Items) != 2 || response.Items[0].Key != "192.0.2.0/24" || response.Items[1].Key != "198.51.100.0/24" {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	rr = httptest.NewRecorder()
+	handleTop(rr, httptest.NewRequest("GET", "/top/clients?by=bounced", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
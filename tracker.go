@@ -0,0 +1,280 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// "smtp[30345]: 923745823B: to=<user@example.com>, relay=..."
+	queueIDLine = `^([\w/-]+)\[\d+\]: ([\dA-F]+): (.+)$`
+
+	trackerMaxRecipients = 100 // per message, to keep memory bounded
+	trackerMaxAttempts   = 100
+)
+
+var reQueueIDLine = regexp.MustCompile(queueIDLine)
+
+// DeliveryAttempt is a single delivery line of a message
+type DeliveryAttempt struct {
+	Time      time.Time `json:"time"`
+	Daemon    string    `json:"daemon"`
+	Recipient string    `json:"to"`
+	Relay     string    `json:"relay,omitempty"`
+	Status    string    `json:"status"`
+	DSN       string    `json:"dsn,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// TrackedMessage is everything we know about a message by its queue ID
+type TrackedMessage struct {
+	QueueID    string            `json:"queue_id"`
+	FirstSeen  time.Time         `json:"first_seen"`
+	Client     string            `json:"client,omitempty"`
+	MessageID  string            `json:"message_id,omitempty"`
+	Sender     string            `json:"from"`
+	Size       uint64            `json:"size"`
+	Recipients []string          `json:"recipients"`
+	Attempts   []DeliveryAttempt `json:"attempts"`
+	Removed    *time.Time        `json:"removed,omitempty"`
+}
+
+// messageTracker keeps recent messages keyed by queue ID. The oldest
+// messages are evicted when they are older than maxAge or there are
+// more than maxCount of them.
+type messageTracker struct {
+	sync.Mutex
+	maxAge   time.Duration
+	maxCount int
+	order    *list.List // *TrackedMessage, the oldest first
+	byID     map[string]*list.Element
+}
+
+var tracker *messageTracker // nil if tracking is disabled or in file mode
+
+func newMessageTracker(maxCount int, maxAge time.Duration) *messageTracker {
+	return &messageTracker{
+		maxAge:   maxAge,
+		maxCount: maxCount,
+		order:    list.New(),
+		byID:     make(map[string]*list.Element),
+	}
+}
+
+// logField returns the value of "name=value" in a delivery or qmgr
+// line, angle brackets around addresses are removed
+func logField(s, name string) string {
+	i := strings.Index(s, name+"=")
+	for i > 0 && s[i-1] != ' ' { // e.g. "orig_to=" while looking for "to="
+		j := strings.Index(s[i+1:], name+"=")
+		if j < 0 {
+			return ""
+		}
+		i += j + 1
+	}
+	if i < 0 {
+		return ""
+	}
+	v := s[i+len(name)+1:]
+	if len(v) > 0 && v[0] == '<' {
+		if j := strings.IndexByte(v, '>'); j >= 0 {
+			return v[1:j]
+		}
+	}
+	if j := strings.IndexAny(v, ", "); j >= 0 {
+		v = v[:j]
+	}
+	return v
+}
+
+// observe updates the tracker with a postfix log line, prefix is the
+// syslog part of it and s is the rest after "postfix/"
+func (t *messageTracker) observe(prefix, s string) {
+	if t == nil {
+		return
+	}
+	m := reQueueIDLine.FindStringSubmatch(s)
+	if m == nil {
+		return
+	}
+	daemon, queueID, rest := m[1], m[2], m[3]
+	if i := strings.LastIndexByte(daemon, '/'); i >= 0 {
+		daemon = daemon[i+1:] // "submission/smtpd" is smtpd
+	}
+	now := time.Now()
+	ts, ok := logLineTime(prefix, now)
+	if !ok {
+		ts = now
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	msg := t.get(queueID)
+	switch {
+	case strings.HasPrefix(rest, "client=") || strings.HasPrefix(rest, "uid="):
+		if msg == nil || msg.Removed != nil { // queue IDs are reused
+			msg = t.add(queueID, ts)
+		}
+		if strings.HasPrefix(rest, "client=") {
+			msg.Client = logField(rest, "client")
+		} else {
+			msg.Sender = logField(rest, "from")
+		}
+	case msg == nil: // we did not see the message arrival
+		if daemon != "qmgr" && daemon != "cleanup" {
+			return
+		}
+		msg = t.add(queueID, ts)
+		fallthrough
+	default:
+		switch {
+		case strings.HasPrefix(rest, "message-id="):
+			msg.MessageID = logField(rest, "message-id")
+		case strings.HasPrefix(rest, "from="):
+			msg.Sender = logField(rest, "from")
+			if sz, err := strconv.ParseUint(logField(rest, "size"), 10, 64); err == nil {
+				msg.Size = sz
+			}
+		case rest == "removed":
+			msg.Removed = &ts
+		case strings.HasPrefix(rest, "to="):
+			t.addAttempt(msg, ts, daemon, rest)
+		}
+	}
+	t.evict(now)
+}
+
+func (t *messageTracker) addAttempt(msg *TrackedMessage, ts time.Time, daemon, s string) {
+	a := DeliveryAttempt{
+		Time:      ts,
+		Daemon:    daemon,
+		Recipient: logField(s, "to"),
+		Relay:     logField(s, "relay"),
+		Status:    logField(s, "status"),
+		DSN:       logField(s, "dsn"),
+	}
+	if a.Status == "" {
+		return
+	}
+	if i := strings.Index(s, "status="+a.Status+" ("); i >= 0 {
+		a.Detail = strings.TrimSuffix(s[i+len(a.Status)+9:], ")")
+	}
+	if len(msg.Attempts) < trackerMaxAttempts {
+		msg.Attempts = append(msg.Attempts, a)
+	}
+	if !strArrayLookup(msg.Recipients, a.Recipient) && len(msg.Recipients) < trackerMaxRecipients {
+		msg.Recipients = append(msg.Recipients, a.Recipient)
+	}
+}
+
+func (t *messageTracker) get(queueID string) *TrackedMessage {
+	if e, ok := t.byID[queueID]; ok {
+		return e.Value.(*TrackedMessage)
+	}
+	return nil
+}
+
+func (t *messageTracker) add(queueID string, ts time.Time) *TrackedMessage {
+	if e, ok := t.byID[queueID]; ok {
+		t.order.Remove(e)
+	}
+	msg := &TrackedMessage{QueueID: queueID, FirstSeen: ts, Recipients: []string{}, Attempts: []DeliveryAttempt{}}
+	t.byID[queueID] = t.order.PushBack(msg)
+	return msg
+}
+
+// evict removes the oldest messages over the limits
+func (t *messageTracker) evict(now time.Time) {
+	for e := t.order.Front(); e != nil; e = t.order.Front() {
+		msg := e.Value.(*TrackedMessage)
+		if t.order.Len() <= t.maxCount && now.Sub(msg.FirstSeen) <= t.maxAge {
+			break
+		}
+		t.order.Remove(e)
+		delete(t.byID, msg.QueueID)
+	}
+}
+
+// lookup returns a copy of a tracked message
+func (t *messageTracker) lookup(queueID string) (TrackedMessage, bool) {
+	t.Lock()
+	defer t.Unlock()
+	msg := t.get(queueID)
+	if msg == nil {
+		return TrackedMessage{}, false
+	}
+	res := *msg
+	res.Recipients = append([]string{}, msg.Recipients...)
+	res.Attempts = append([]DeliveryAttempt{}, msg.Attempts...)
+	return res, true
+}
+
+func (m *TrackedMessage) String() string {
+	const tf = "2006-01-02 15:04:05"
+	res := fmt.Sprintf("%-12s%s\n", "queue-id", m.QueueID)
+	res += fmt.Sprintf("%-12s%s\n", "first-seen", m.FirstSeen.Format(tf))
+	if m.Client != "" {
+		res += fmt.Sprintf("%-12s%s\n", "client", m.Client)
+	}
+	if m.MessageID != "" {
+		res += fmt.Sprintf("%-12s<%s>\n", "message-id", m.MessageID)
+	}
+	res += fmt.Sprintf("%-12s<%s>\n", "from", m.Sender)
+	res += fmt.Sprintf("%-12s%d\n", "size", m.Size)
+	for _, r := range m.Recipients {
+		res += fmt.Sprintf("%-12s<%s>\n", "to", r)
+	}
+	for _, a := range m.Attempts {
+		res += fmt.Sprintf("%-12s%s %s <%s> relay=%s dsn=%s status=%s", "attempt",
+			a.Time.Format(tf), a.Daemon, a.Recipient, a.Relay, a.DSN, a.Status)
+		if a.Detail != "" {
+			res += " (" + a.Detail + ")"
+		}
+		res += "\n"
+	}
+	if m.Removed != nil {
+		res += fmt.Sprintf("%-12s%s\n", "removed", m.Removed.Format(tf))
+	}
+	return res
+}
+
+// traceCmd is the socket "trace <queueid>" command
+func traceCmd(queueID string) string {
+	if tracker == nil {
+		return "Message tracking is disabled\n"
+	}
+	msg, ok := tracker.lookup(queueID)
+	if !ok {
+		return fmt.Sprintf("Message %s is not found\n", queueID)
+	}
+	return msg.String()
+}
+
+// handleMessage обрабатывает запрос /message/{queueid}
+func handleMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if tracker == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Message tracking is disabled"})
+		return
+	}
+
+	queueID := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/message/"))
+	msg, ok := tracker.lookup(queueID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error: fmt.Sprintf("Message %s is not found", queueID),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(msg)
+}
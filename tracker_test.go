@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var trackerTestLog = []string{
+	"Apr  1 10:00:00 mail postfix/smtpd[1000]: AD59432D65: client=mail1.example.com[123.123.123.123]",
+	"Apr  1 10:00:00 mail postfix/cleanup[1001]: AD59432D65: message-id=<20250401100000.1@example.com>",
+	"Apr  1 10:00:01 mail postfix/qmgr[1002]: AD59432D65: from=<alice@example.com>, size=2048, nrcpt=2 (queue active)",
+	"Apr  1 10:00:02 mail postfix/smtp[1003]: AD59432D65: to=<bob@example.net>, relay=mx.example.net[1.2.3.4]:25, delay=2, delays=0.1/0/1/0.9, dsn=2.0.0, status=sent (250 2.0.0 Ok: queued as 123)",
+	"Apr  1 10:00:03 mail postfix/smtp[1003]: AD59432D65: to=<carol@example.org>, orig_to=<c@example.org>, relay=none, delay=3, delays=0.1/0/3/0, dsn=4.4.1, status=deferred (connect to mx.example.org[5.6.7.8]:25: Connection timed out)",
+	"Apr  1 10:30:03 mail postfix/smtp[1004]: AD59432D65: to=<carol@example.org>, orig_to=<c@example.org>, relay=mx.example.org[5.6.7.8]:25, delay=1800, delays=1800/0/1/0, dsn=2.0.0, status=sent (250 Ok)",
+	"Apr  1 10:30:03 mail postfix/qmgr[1002]: AD59432D65: removed",
+}
+
+func TestLogField(t *testing.T) {
+	s := "to=<carol@example.org>, orig_to=<c@example.org>, relay=none, delay=3, dsn=4.4.1, status=deferred (timed out)"
+	tests := map[string]string{
+		"to":      "carol@example.org",
+		"orig_to": "c@example.org",
+		"relay":   "none",
+		"dsn":     "4.4.1",
+		"status":  "deferred",
+		"size":    "",
+	}
+	for name, want := range tests {
+		if got := logField(s, name); got != want {
+			t.Errorf("logField(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestMessageTracker(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	tracker = newMessageTracker(100, 1000000*time.Hour)
+	defer func() { tracker = nil }()
+	for _, l := range trackerTestLog {
+		PostfixLineParse(l)
+	}
+
+	msg, ok := tracker.lookup("AD59432D65")
+	if !ok {
+		t.Fatal("message AD59432D65 is not tracked")
+	}
+	if msg.Client != "mail1.example.com[123.123.123.123]" {
+		t.Errorf("unexpected client %q", msg.Client)
+	}
+	if msg.Sender != "alice@example.com" || msg.Size != 2048 || msg.MessageID != "20250401100000.1@example.com" {
+		t.Errorf("unexpected message data %+v", msg)
+	}
+	if len(msg.Recipients) != 2 || len(msg.Attempts) != 3 {
+		t.Fatalf("Expected 2 recipients and 3 attempts, got %v and %d", msg.Recipients, len(msg.Attempts))
+	}
+	a := msg.Attempts[1]
+	if a.Recipient != "carol@example.org" || a.Status != "deferred" || a.DSN != "4.4.1" ||
+		a.Detail != "connect to mx.example.org[5.6.7.8]:25: Connection timed out" {
+		t.Errorf("unexpected attempt %+v", a)
+	}
+	if msg.Removed == nil || msg.Removed.Format("15:04:05") != "10:30:03" {
+		t.Errorf("unexpected removal time %v", msg.Removed)
+	}
+
+	trace := traceCmd("AD59432D65")
+	if !strings.Contains(trace, "status=deferred (connect to") {
+		t.Errorf("unexpected trace output:\n%s", trace)
+	}
+}
+
+func TestMessageTrackerEviction(t *testing.T) {
+	tr := newMessageTracker(2, time.Hour)
+	now := time.Now()
+	prefix := now.Format(syslogTimeLayout) + " mail postfix/"
+	for _, id := range []string{"A1", "A2", "A3"} {
+		tr.observe(prefix, "smtpd[1]: "+id+": client=unknown[10.0.0.1]")
+	}
+	if _, ok := tr.lookup("A1"); ok {
+		t.Error("A1 should be evicted by the count limit")
+	}
+	if _, ok := tr.lookup("A3"); !ok {
+		t.Error("A3 should be tracked")
+	}
+
+	old := now.Add(-2*time.Hour).Format(syslogTimeLayout) + " mail postfix/"
+	tr = newMessageTracker(10, time.Hour)
+	tr.observe(old, "smtpd[1]: B1: client=unknown[10.0.0.1]")
+	tr.observe(prefix, "smtpd[1]: B2: client=unknown[10.0.0.1]")
+	if _, ok := tr.lookup("B1"); ok {
+		t.Error("B1 should be evicted by the age limit")
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	tracker = newMessageTracker(100, 1000000*time.Hour)
+	defer func() { tracker = nil }()
+	for _, l := range trackerTestLog {
+		PostfixLineParse(l)
+	}
+
+	rr := httptest.NewRecorder()
+	handleMessage(rr, httptest.NewRequest("GET", "/message/AD59432D65", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var msg TrackedMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.QueueID != "AD59432D65" || len(msg.Attempts) != 3 {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	rr = httptest.NewRecorder()
+	handleMessage(rr, httptest.NewRequest("GET", "/message/0000000000", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
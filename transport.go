@@ -0,0 +1,28 @@
+package main
+
+// transportCounters split delivered, deferred and bounced deliveries
+// and delivered bytes by the delivering daemon: smtp, lmtp, local,
+// virtual, pipe, error, discard
+var transportCounters = counterGroup{
+	name: "transport",
+	labeled: []labeledCounter{
+		{name: "delivered-transport", label: "transport"},
+		{name: "deferred-transport", label: "transport"},
+		{name: "bounced-transport", label: "transport"},
+		{name: "bytes-delivered-transport", label: "transport"},
+	},
+}
+
+// transportLineParse counts a delivery line "smtp[123]: QID: to=<...>, ..."
+// by its daemon, status is "delivered", "deferred" or "bounced" and size
+// is the delivered message size. The caller should hold the lock.
+func transportLineParse(status, s string, size uint64) {
+	daemon, _, ok := postfixDaemon(s)
+	if !ok {
+		return
+	}
+	msgStatusCounters.incLabel(status+"-transport", daemon, 1)
+	if status == "delivered" {
+		msgStatusCounters.incLabel("bytes-delivered-transport", daemon, size)
+	}
+}
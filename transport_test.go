@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTransportLineParse(t *testing.T) {
+	PostfixParserInit(&Config{cmd: "file"})
+	prefix := "Apr  1 10:00:00 mail postfix/"
+	lines := []string{
+		"smtpd[100]: 0A2D132D5F: client=unknown[192.0.2.1]",
+		"qmgr[101]: 0A2D132D5F: from=<a@example.com>, size=1000, nrcpt=3 (queue active)",
+		"smtp[200]: 0A2D132D5F: to=<a@example.net>, relay=mx.example.net[198.51.100.1]:25, dsn=2.0.0, status=sent (250 Ok)",
+		"lmtp[201]: 0A2D132D5F: to=<b@example.org>, relay=mail.example.org[private/dovecot-lmtp], dsn=2.0.0, status=sent (250 Ok)\n",
+		"relay/smtp[202]: 0A2D132D5F: to=<c@example.com>, relay=none, dsn=4.4.1, status=deferred (connect to mx.example.com[198.51.100.2]:25: Connection timed out)",
+		"qmgr[101]: 1B2D132D5F: from=<b@example.com>, size=200, nrcpt=2 (queue active)",
+		"pipe[203]: 1B2D132D5F: to=<tickets@example.org>, relay=rt, dsn=2.0.0, status=sent (delivered via rt service)",
+		"virtual[204]: 1B2D132D5F: to=<x@example.org>, relay=virtual, dsn=5.1.1, status=bounced (unknown user)",
+		"error[205]: 1B2D132D5F: to=<y@example.org>, relay=none, dsn=5.0.0, status=bounced (mail for example.org loops back to myself)",
+		"local[206]: 1B2D132D5F: to=<z@example.org>, relay=local, dsn=2.0.0, status=sent (forwarded as 2C2D132D5F)",
+	}
+	for _, l := range lines {
+		PostfixLineParse(prefix + l)
+	}
+
+	labeled := map[string]map[string]uint64{
+		"delivered-transport":       {"smtp": 1, "lmtp": 1, "pipe": 1},
+		"deferred-transport":        {"smtp": 1},
+		"bounced-transport":         {"virtual": 1, "error": 1},
+		"bytes-delivered-transport": {"smtp": 1000, "lmtp": 1000, "pipe": 200},
+	}
+	for name, want := range labeled {
+		if got := msgStatusCounters.labeled[name]; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %s=%v, got %v", name, want, got)
+		}
+	}
+	if got := msgStatusCounters.counters["bytes-delivered"]; got != 2200 {
+		t.Errorf("Expected bytes-delivered=2200, got %d", got)
+	}
+}